@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"github.com/solusio/import-vmware/ssh"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What to do with a Windows virtual machine which was not shut down cleanly before disks import.
+const (
+	UncleanShutdownActionRefuse = "refuse"
+	UncleanShutdownActionWarn   = "warn"
+	UncleanShutdownActionFix    = "fix"
+)
+
+func isValidUncleanShutdownAction(action string) bool {
+	switch action {
+	case UncleanShutdownActionRefuse, UncleanShutdownActionWarn, UncleanShutdownActionFix:
+		return true
+	}
+	return false
+}
+
+// uncleanShutdownReasons returns reasons why file systems of a Windows guest may be not cleanly unmounted,
+// virt-v2v fails on such file systems at the end of the conversion. dir is the directory of VMX file vmxPath.
+func uncleanShutdownReasons(v VMXFile, vmxPath string, dir []fs.DirEntry) []string {
+	if !isWindowsGuestOS(v.GuestOS) {
+		return nil
+	}
+
+	var reasons []string
+
+	// Keys are absent for a virtual machine which was never powered on.
+	if clean, err := strconv.ParseBool(v.CleanShutdown); err == nil && !clean {
+		reasons = append(reasons, "cleanShutdown is FALSE, the guest was not shut down cleanly")
+	}
+	if soft, err := strconv.ParseBool(v.SoftPowerOff); err == nil && !soft {
+		reasons = append(reasons, "softPowerOff is FALSE, the virtual machine was powered off without guest shutdown")
+	}
+
+	base := strings.TrimSuffix(filepath.Base(vmxPath), filepath.Ext(vmxPath))
+	for _, f := range dir {
+		switch {
+		case isVMStateFile(f.Name(), base, ".vmss"):
+			reasons = append(reasons, fmt.Sprintf("suspend state file %s exists, the virtual machine is suspended", f.Name()))
+		case isVMStateFile(f.Name(), base, ".vmem"):
+			reasons = append(reasons, fmt.Sprintf("memory file %s exists, the virtual machine is running or suspended", f.Name()))
+		}
+	}
+
+	return reasons
+}
+
+// isVMStateFile returns true if name is a state file of the virtual machine like <vm>.vmss,
+// <vm>-<uuid>.vmss or <vm>-<uuid>.vmem swap of the running virtual machine. Memory files of snapshots
+// like <vm>-Snapshot1.vmem are kept while the snapshot exists, so they don't mean unclean shutdown.
+func isVMStateFile(name, base, ext string) bool {
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		return false
+	}
+
+	stem := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+	base = strings.ToLower(base)
+	if stem == base {
+		return true
+	}
+
+	suffix, ok := strings.CutPrefix(stem, base+"-")
+	return ok && !strings.HasPrefix(suffix, "snapshot")
+}
+
+// checkCleanShutdown reads VMX file and the virtual machine directory and returns reasons of unclean shutdown.
+func checkCleanShutdown(fsys FS, vs VirtualServer) ([]string, error) {
+	v, err := ParseVMXFile(fsys, vs.VMXFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := fsys.ReadDir(filepath.Dir(vs.VMXFilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	return uncleanShutdownReasons(v, vs.VMXFilePath, dir), nil
+}
+
+// ensureCleanShutdown checks a powered off Windows virtual machine was shut down cleanly before
+// multi-hour conversion, which fails at the end otherwise. The fix action boots the guest and shuts it down.
+func ensureCleanShutdown(node *ssh.NodeConnection, vs VirtualServer, action string, timeout time.Duration) error {
+	if node == nil {
+		return fmt.Errorf("virtual server %q clean shutdown check requires connection to the source host", vs.OriginName)
+	}
+
+	fsys := sftpFS{conn: node.Connection()}
+
+	reasons, err := checkCleanShutdown(fsys, vs)
+	if err != nil {
+		return fmt.Errorf("check clean shutdown of virtual server %q: %w", vs.OriginName, err)
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	report := strings.Join(reasons, "; ")
+
+	switch action {
+	case UncleanShutdownActionWarn:
+		log.Printf("virtual server %q may be not shut down cleanly, conversion may fail: %s", vs.OriginName, report)
+		return nil
+	case UncleanShutdownActionFix:
+	default:
+		return fmt.Errorf("virtual server %q was not shut down cleanly: %s; boot and shut it down from the guest, "+
+			"disable Hibernation and Fast Startup, or use another unclean shutdown action", vs.OriginName, report)
+	}
+
+	log.Printf("virtual server %q was not shut down cleanly, boot and shut it down: %s", vs.OriginName, report)
+
+	id, err := findESXiVMID(*node, vs.VMXFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := bootAndShutdownVM(*node, id, vs, timeout); err != nil {
+		return err
+	}
+
+	if reasons, err := checkCleanShutdown(fsys, vs); err != nil {
+		return err
+	} else if len(reasons) > 0 {
+		return fmt.Errorf("virtual server %q is still not shut down cleanly: %s", vs.OriginName, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// bootAndShutdownVM powers on the virtual machine, waits for VMware Tools and shuts the guest down,
+// so its file systems are cleanly unmounted.
+func bootAndShutdownVM(node ssh.NodeConnection, id int, vs VirtualServer, timeout time.Duration) error {
+	if out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/power.on %d", id)); err != nil {
+		return fmt.Errorf("power on vm %d %s: %w", id, string(out), err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("VMware Tools of virtual server %q are not running in %s, shut it down manually", vs.OriginName, timeout)
+		}
+
+		time.Sleep(powerStatePollInterval)
+
+		out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/get.guest %d", id))
+		if err == nil && strings.Contains(string(out), "guestToolsRunning") {
+			break
+		}
+	}
+
+	// A forced power off makes file systems unclean again, so it's not allowed.
+	return shutdownVM(node, id, vs, runningVMOptions{
+		ShutdownTimeout: timeout,
+	})
+}
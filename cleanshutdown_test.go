@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUncleanShutdownReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		vmx     VMXFile
+		files   []string
+		wantLen int
+	}{
+		{
+			name:  "clean shutdown with memory snapshots",
+			vmx:   VMXFile{GuestOS: "windows2019srv-64", CleanShutdown: "TRUE", SoftPowerOff: "TRUE"},
+			files: []string{"win.vmx", "win.vmdk", "win.vmsd", "win-Snapshot1.vmem", "win-Snapshot1.vmsn", "win-Snapshot2.vmem"},
+		},
+		{
+			name:    "running swap file",
+			vmx:     VMXFile{GuestOS: "windows2019srv-64"},
+			files:   []string{"win.vmx", "win-5c8a2f4e.vmem"},
+			wantLen: 1,
+		},
+		{
+			name:    "suspended",
+			vmx:     VMXFile{GuestOS: "winNetStandard"},
+			files:   []string{"win.vmx", "win.vmss", "win.vmem"},
+			wantLen: 2,
+		},
+		{
+			name:    "suspended with uuid state file",
+			vmx:     VMXFile{GuestOS: "windows2019srv-64", CleanShutdown: "TRUE", SoftPowerOff: "TRUE"},
+			files:   []string{"win.vmx", "win-1a2b3c4d.vmss"},
+			wantLen: 1,
+		},
+		{
+			name:  "state files of another virtual machine",
+			vmx:   VMXFile{GuestOS: "windows2019srv-64"},
+			files: []string{"win.vmx", "other.vmss", "other-5c8a2f4e.vmem", "winter.vmem"},
+		},
+		{
+			name:    "powered off without guest shutdown",
+			vmx:     VMXFile{GuestOS: "windows9-64", CleanShutdown: "FALSE", SoftPowerOff: "FALSE"},
+			files:   []string{"win.vmx"},
+			wantLen: 2,
+		},
+		{
+			name:  "not windows",
+			vmx:   VMXFile{GuestOS: "ubuntu-64", CleanShutdown: "FALSE"},
+			files: []string{"win.vmx", "win.vmss"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := memFS{}
+			for _, name := range tt.files {
+				fsys["/vmfs/volumes/datastore1/win/"+name] = memFile("")
+			}
+
+			dir, err := fsys.ReadDir("/vmfs/volumes/datastore1/win")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reasons := uncleanShutdownReasons(tt.vmx, "/vmfs/volumes/datastore1/win/win.vmx", dir)
+			if len(reasons) != tt.wantLen {
+				t.Errorf("reasons are %q, expected %d", reasons, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestIsVMStateFile(t *testing.T) {
+	tests := []struct {
+		name string
+		ext  string
+		want bool
+	}{
+		{name: "vm.vmss", ext: ".vmss", want: true},
+		{name: "vm-1a2b3c4d.vmss", ext: ".vmss", want: true},
+		{name: "VM-1A2B3C4D.VMSS", ext: ".vmss", want: true},
+		{name: "vm-1a2b3c4d.vmem", ext: ".vmem", want: true},
+		{name: "vm-Snapshot1.vmem", ext: ".vmem"},
+		{name: "vm-1a2b3c4d.vmss", ext: ".vmem"},
+		{name: "vmx-1a2b3c4d.vmss", ext: ".vmss"},
+	}
+
+	for _, tt := range tests {
+		if got := isVMStateFile(tt.name, "vm", tt.ext); got != tt.want {
+			t.Errorf("isVMStateFile(%q, %q) = %t, expected %t", tt.name, tt.ext, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/solusio/import-vmware/common"
+	"github.com/solusio/import-vmware/ssh"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// temporaryCloneDirName is a directory in the virtual machine directory on the source host,
+// disks which virt-v2v can't read over SSH are cloned there right before conversion.
+const temporaryCloneDirName = ".import-vmware-clone"
+
+// needsTemporaryClone returns true if virt-v2v can't read the disk over SSH as is: its current
+// state is a delta disk of a snapshot, or it's not a flat disk.
+func (d Disk) needsTemporaryClone(snapshotMode string) bool {
+	if len(d.SnapshotChain) > 0 && snapshotMode == SnapshotModeCurrent {
+		return true
+	}
+
+	// Plans created before VMDK descriptors parsing have no create type.
+	return d.CreateType != "" && !isDirectlyImportableCreateType(d.CreateType)
+}
+
+// needsTemporaryClone returns true if any disk of the virtual server is imported from a temporary clone.
+func (vs VirtualServer) needsTemporaryClone() bool {
+	for _, d := range vs.disksInConversionOrder() {
+		if d.needsTemporaryClone(vs.SnapshotMode) {
+			return true
+		}
+	}
+	return false
+}
+
+// temporaryClone is a copy of a virtual machine on the source host which virt-v2v can read over SSH.
+type temporaryClone struct {
+	node ssh.NodeConnection
+	dir  string
+
+	// VMXFilePath is a copy of VMX file which references cloned disks.
+	VMXFilePath string
+}
+
+// cloneVirtualServer clones disks of the virtual server which virt-v2v can't read over SSH to thin
+// VMFS disks with `vmkfstools -i`, a snapshot chain is merged into the clone and kept on the source.
+// A copy of VMX file references the clones and original paths of other disks.
+func cloneVirtualServer(node *ssh.NodeConnection, vs VirtualServer) (*temporaryClone, error) {
+	if node == nil {
+		return nil, fmt.Errorf("virtual server %q disks cloning requires connection to the source host", vs.OriginName)
+	}
+
+	dir := path.Join(path.Dir(vs.VMXFilePath), temporaryCloneDirName)
+	c := &temporaryClone{
+		node:        *node,
+		dir:         dir,
+		VMXFilePath: path.Join(dir, path.Base(vs.VMXFilePath)),
+	}
+
+	// A clone left by an interrupted import is made again.
+	if err := c.Remove(); err != nil {
+		return nil, err
+	}
+
+	if out, err := node.Exec(ssh.ShellCommand("mkdir", "-p", dir)); err != nil {
+		return nil, fmt.Errorf("create directory %q %s: %w", dir, string(out), err)
+	}
+
+	if err := c.clone(vs); err != nil {
+		if rErr := c.Remove(); rErr != nil {
+			log.Printf("failed to remove temporary clone of virtual server %q: %s", vs.OriginName, rErr)
+		}
+		return nil, fmt.Errorf("clone virtual server %q: %w", vs.OriginName, err)
+	}
+
+	return c, nil
+}
+
+func (c *temporaryClone) clone(vs VirtualServer) error {
+	filenames := map[string]string{}
+	for _, d := range vs.disksInConversionOrder() {
+		if d.Device == "" {
+			return fmt.Errorf("disk %q has no device in the import plan, create the import plan again", d.SourcePath)
+		}
+
+		source := d.importSourcePath(vs.SnapshotMode)
+		if !d.needsTemporaryClone(vs.SnapshotMode) {
+			filenames[d.Device] = source
+			continue
+		}
+
+		name := strings.ReplaceAll(d.Device, ":", "-") + ".vmdk"
+		target := path.Join(c.dir, name)
+
+		log.Printf("clone disk %q of virtual server %q to %q", source, vs.OriginName, target)
+		if out, err := c.node.Exec(ssh.ShellCommand("vmkfstools", "-i", source, "-d", "thin", target)); err != nil {
+			return fmt.Errorf("clone disk %q %s: %w", source, string(out), err)
+		}
+		filenames[d.Device] = name
+	}
+
+	r, err := c.node.Connection().Download(context.Background(), vs.VMXFilePath)
+	if err != nil {
+		return fmt.Errorf("download vmx file: %w", err)
+	}
+	defer common.CloseWrapper(r)
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read vmx file %q: %w", vs.VMXFilePath, err)
+	}
+
+	b, err = rewriteVMXDiskFilenames(b, filenames)
+	if err != nil {
+		return err
+	}
+
+	if err := c.node.Connection().Upload(context.Background(), bytes.NewReader(b), c.dir, path.Base(c.VMXFilePath)); err != nil {
+		return fmt.Errorf("upload vmx file %q: %w", c.VMXFilePath, err)
+	}
+
+	return nil
+}
+
+// Remove removes the clone from the source host.
+func (c *temporaryClone) Remove() error {
+	if out, err := c.node.Exec(ssh.ShellCommand("rm", "-rf", c.dir)); err != nil {
+		return fmt.Errorf("remove directory %q %s: %w", c.dir, string(out), err)
+	}
+	return nil
+}
+
+// vmxValueReplacer escapes a VMX value like ESXi does, `|` and `"` are written by hex code.
+var vmxValueReplacer = strings.NewReplacer("|", "|7C", `"`, "|22")
+
+// rewriteVMXDiskFilenames sets file names of disks by their device IDs like scsi0:0 in VMX file.
+// Other lines are kept as is.
+func rewriteVMXDiskFilenames(b []byte, filenames map[string]string) ([]byte, error) {
+	lines := strings.SplitAfter(string(b), "\n")
+	found := map[string]bool{}
+
+	for i, line := range lines {
+		key, _, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+
+		for device, filename := range filenames {
+			if !strings.EqualFold(key, device+".fileName") {
+				continue
+			}
+
+			eol := line[len(strings.TrimRight(line, "\r\n")):]
+			lines[i] = key + ` = "` + vmxValueReplacer.Replace(filename) + `"` + eol
+			found[device] = true
+		}
+	}
+
+	devices := make([]string, 0, len(filenames))
+	for device := range filenames {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		if !found[device] {
+			return nil, fmt.Errorf("vmx file has no file name of disk %s", device)
+		}
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteVMXDiskFilenames(t *testing.T) {
+	vmxFile := "displayName = \"db\"\r\n" +
+		"scsi0:0.fileName = \"db-000001.vmdk\"\r\n" +
+		"SATA0:0.FILENAME = \"/vmfs/volumes/datastore2/db/db_1.vmdk\"\r\n" +
+		"sata0:0.present = \"TRUE\"\r\n"
+
+	got, err := rewriteVMXDiskFilenames([]byte(vmxFile), map[string]string{
+		"scsi0:0": "scsi0-0.vmdk",
+		"sata0:0": `/vmfs/volumes/datastore 2/db "1"/db_1.vmdk`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "displayName = \"db\"\r\n" +
+		"scsi0:0.fileName = \"scsi0-0.vmdk\"\r\n" +
+		"SATA0:0.FILENAME = \"/vmfs/volumes/datastore 2/db |221|22/db_1.vmdk\"\r\n" +
+		"sata0:0.present = \"TRUE\"\r\n"
+	if string(got) != want {
+		t.Errorf("vmx file is\n%s\nexpected\n%s", got, want)
+	}
+
+	_, err = rewriteVMXDiskFilenames([]byte(vmxFile), map[string]string{"nvme0:0": "nvme0-0.vmdk"})
+	if err == nil || !strings.Contains(err.Error(), "nvme0:0") {
+		t.Errorf("expected error for missing disk, got %v", err)
+	}
+}
+
+func TestVirtualServerNeedsTemporaryClone(t *testing.T) {
+	flat := Disk{Device: "scsi0:0", CreateType: VMDKCreateTypeVMFS}
+	withSnapshot := flat
+	withSnapshot.SnapshotChain = []string{"/vmfs/volumes/ds1/db/db-000001.vmdk", "/vmfs/volumes/ds1/db/db.vmdk"}
+	sparse := Disk{Device: "scsi0:1", CreateType: VMDKCreateTypeSESparse}
+
+	tests := []struct {
+		name string
+		vs   VirtualServer
+		want bool
+	}{
+		{name: "flat", vs: VirtualServer{PrimaryDisk: &flat}},
+		// Plans created before VMDK descriptors parsing have no create type.
+		{name: "unknown create type", vs: VirtualServer{PrimaryDisk: &Disk{Device: "scsi0:0"}}},
+		{name: "current state of snapshot", vs: VirtualServer{PrimaryDisk: &withSnapshot, SnapshotMode: SnapshotModeCurrent}, want: true},
+		{name: "consolidated snapshot", vs: VirtualServer{PrimaryDisk: &withSnapshot, SnapshotMode: SnapshotModeConsolidate}},
+		{name: "sparse additional disk", vs: VirtualServer{PrimaryDisk: &flat, AdditionalDisks: []Disk{sparse}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.vs.needsTemporaryClone(); got != tt.want {
+				t.Errorf("needsTemporaryClone() = %t, expected %t", got, tt.want)
+			}
+		})
+	}
+}
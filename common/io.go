@@ -23,24 +23,3 @@ func CloseWrapper(r ...io.Closer) {
 		}
 	}
 }
-
-func Copy(srcFile, dstFile string) error {
-	out, err := os.Create(dstFile)
-	defer CloseWrapper(out)
-	if err != nil {
-		return err
-	}
-
-	in, err := os.Open(srcFile)
-	defer CloseWrapper(in)
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/solusio/import-vmware/command"
+	"github.com/solusio/import-vmware/common"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// targetDevRegexp matches a guest disk device name like sda, vdb or hdc.
+var targetDevRegexp = regexp.MustCompile(`^(?:sd|vd|hd|xvd)([a-z]+)$`)
+
+// targetDevIndex returns an index of a guest disk device, 0 for sda, 1 for sdb and 26 for sdaa.
+func targetDevIndex(dev string) (int, bool) {
+	m := targetDevRegexp.FindStringSubmatch(dev)
+	if m == nil {
+		return 0, false
+	}
+
+	index := 0
+	for _, c := range m[1] {
+		index = index*26 + int(c-'a') + 1
+	}
+	return index - 1, true
+}
+
+// diskMapping is a converted disk matched to a disk of the virtual server.
+type diskMapping struct {
+	Converted domainDisk
+	Disk      Disk
+}
+
+// matchConvertedDisks matches every converted disk to a disk of the virtual server by its identity:
+// the target device, which virt-v2v assigns in the order of VMX disks, and the virtual size, which must be
+// equal to the source disk capacity. sizeOf returns the virtual size of a converted disk.
+// It fails on any ambiguity instead of guessing, since a swapped system and data disk loses data.
+func matchConvertedDisks(vs VirtualServer, converted []domainDisk, sizeOf func(domainDisk, Disk) (int64, error)) ([]diskMapping, error) {
+	expected := vs.disksInConversionOrder()
+	if len(converted) != len(expected) {
+		return nil, fmt.Errorf("number of converted disks is %d, but %d expected", len(converted), len(expected))
+	}
+
+	mappings := make([]diskMapping, 0, len(converted))
+
+	for position, c := range converted {
+		index, ok := targetDevIndex(c.device)
+		if !ok {
+			return nil, fmt.Errorf("converted disk %q has unknown target device %q", c.path, c.device)
+		}
+
+		if index != position {
+			return nil, fmt.Errorf("converted disk %q target device %s does not match its position %d", c.path, c.device, position)
+		}
+
+		// virt-v2v names output disks like <name>-sda whatever the bus is, while the target device
+		// is vda for virtio-blk, so drive indexes are compared.
+		base := filepath.Base(c.path)
+		if i := strings.LastIndex(base, "-"); i >= 0 {
+			if nameIndex, ok := targetDevIndex(base[i+1:]); ok && nameIndex != index {
+				return nil, fmt.Errorf("converted disk %q name does not match its target device %s", c.path, c.device)
+			}
+		}
+
+		disk := expected[index]
+		if disk.DestinationPath == "" {
+			return nil, fmt.Errorf("source disk %q has no destination path, create virtual server first", disk.SourcePath)
+		}
+
+		size, err := sizeOf(c, disk)
+		if err != nil {
+			return nil, fmt.Errorf("get size of converted disk %q: %w", c.path, err)
+		}
+
+		if err := checkConvertedDiskSize(disk, size); err != nil {
+			return nil, fmt.Errorf("converted disk %q (%s) does not match source disk %q: %w", c.path, c.device, disk.SourcePath, err)
+		}
+
+		mappings = append(mappings, diskMapping{
+			Converted: c,
+			Disk:      disk,
+		})
+	}
+
+	return mappings, nil
+}
+
+func checkConvertedDiskSize(disk Disk, size int64) error {
+	switch {
+	case disk.CapacityBytes > 0:
+		if size != disk.CapacityBytes {
+			return fmt.Errorf("virtual size is %d bytes, but source disk capacity is %d bytes", size, disk.CapacityBytes)
+		}
+	case disk.Size > 0:
+		// Plans created before VMDK descriptors parsing have disk size in GiB only.
+		if sizeGiB := int((size + common.GiB - 1) / common.GiB); sizeGiB != disk.Size {
+			return fmt.Errorf("virtual size is %d GiB, but source disk size is %d GiB", sizeGiB, disk.Size)
+		}
+	default:
+		return fmt.Errorf("source disk size is unknown")
+	}
+	return nil
+}
+
+// convertedDiskSize returns the virtual size of a converted disk. The disk may be already moved
+// to the destination path by a previous attempt.
+func convertedDiskSize(c domainDisk, disk Disk) (int64, error) {
+	path := c.path
+	if !common.IsExists(path) && common.IsExists(disk.DestinationPath) {
+		path = disk.DestinationPath
+	}
+
+	var out bytes.Buffer
+	err := command.DefaultCommander.Build("qemu-img", "info", "--output=json", path).
+		WithStdOut(&out).
+		WithNoInfoLog().
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		return 0, fmt.Errorf("decode qemu-img info: %w", err)
+	}
+
+	return info.VirtualSize, nil
+}
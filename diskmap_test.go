@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testTwoDiskVirtualServer() VirtualServer {
+	return VirtualServer{
+		OriginName:                 "vm",
+		PrimaryDiskSourcePath:      "/vmfs/volumes/ds1/vm/vm.vmdk",
+		PrimaryDiskDestinationPath: "/var/lib/libvirt/images/1",
+		PrimaryDisk: &Disk{
+			SourcePath:    "/vmfs/volumes/ds1/vm/vm.vmdk",
+			Device:        "scsi0:0",
+			CapacityBytes: 10 << 30,
+		},
+		AdditionalDisks: []Disk{
+			{
+				SourcePath:      "/vmfs/volumes/ds1/vm/vm_1.vmdk",
+				DestinationPath: "/var/lib/libvirt/images/1-1",
+				Device:          "scsi0:1",
+				CapacityBytes:   20 << 30,
+			},
+		},
+	}
+}
+
+func TestMatchConvertedDisks(t *testing.T) {
+	tests := []struct {
+		name      string
+		converted []domainDisk
+		sizes     map[string]int64
+		wantErr   string
+	}{
+		{
+			name: "virtio",
+			converted: []domainDisk{
+				{device: "vda", bus: "virtio", path: "/tmp/out/vm-sda"},
+				{device: "vdb", bus: "virtio", path: "/tmp/out/vm-sdb"},
+			},
+			sizes: map[string]int64{"/tmp/out/vm-sda": 10 << 30, "/tmp/out/vm-sdb": 20 << 30},
+		},
+		{
+			name: "scsi",
+			converted: []domainDisk{
+				{device: "sda", bus: "scsi", path: "/tmp/out/vm-sda"},
+				{device: "sdb", bus: "scsi", path: "/tmp/out/vm-sdb"},
+			},
+			sizes: map[string]int64{"/tmp/out/vm-sda": 10 << 30, "/tmp/out/vm-sdb": 20 << 30},
+		},
+		{
+			name: "ide",
+			converted: []domainDisk{
+				{device: "hda", bus: "ide", path: "/tmp/out/vm-sda"},
+				{device: "hdb", bus: "ide", path: "/tmp/out/vm-sdb"},
+			},
+			sizes: map[string]int64{"/tmp/out/vm-sda": 10 << 30, "/tmp/out/vm-sdb": 20 << 30},
+		},
+		{
+			name: "swapped sizes",
+			converted: []domainDisk{
+				{device: "vda", bus: "virtio", path: "/tmp/out/vm-sda"},
+				{device: "vdb", bus: "virtio", path: "/tmp/out/vm-sdb"},
+			},
+			sizes:   map[string]int64{"/tmp/out/vm-sda": 20 << 30, "/tmp/out/vm-sdb": 10 << 30},
+			wantErr: "does not match source disk",
+		},
+		{
+			name: "name of other drive",
+			converted: []domainDisk{
+				{device: "vda", bus: "virtio", path: "/tmp/out/vm-sdb"},
+				{device: "vdb", bus: "virtio", path: "/tmp/out/vm-sda"},
+			},
+			sizes:   map[string]int64{"/tmp/out/vm-sda": 20 << 30, "/tmp/out/vm-sdb": 10 << 30},
+			wantErr: "name does not match its target device",
+		},
+		{
+			name: "target device out of order",
+			converted: []domainDisk{
+				{device: "vdb", bus: "virtio", path: "/tmp/out/vm-sdb"},
+				{device: "vda", bus: "virtio", path: "/tmp/out/vm-sda"},
+			},
+			sizes:   map[string]int64{"/tmp/out/vm-sda": 10 << 30, "/tmp/out/vm-sdb": 20 << 30},
+			wantErr: "does not match its position",
+		},
+		{
+			name: "missing disk",
+			converted: []domainDisk{
+				{device: "vda", bus: "virtio", path: "/tmp/out/vm-sda"},
+			},
+			sizes:   map[string]int64{"/tmp/out/vm-sda": 10 << 30},
+			wantErr: "number of converted disks is 1, but 2 expected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizeOf := func(c domainDisk, _ Disk) (int64, error) {
+				return tt.sizes[c.path], nil
+			}
+
+			mappings, err := matchConvertedDisks(testTwoDiskVirtualServer(), tt.converted, sizeOf)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			want := []string{"/var/lib/libvirt/images/1", "/var/lib/libvirt/images/1-1"}
+			if len(mappings) != len(want) {
+				t.Fatalf("expected %d mappings, got %d", len(want), len(mappings))
+			}
+			for i, m := range mappings {
+				if m.Disk.DestinationPath != want[i] {
+					t.Errorf("disk %q is mapped to %q, expected %q", m.Converted.path, m.Disk.DestinationPath, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTargetDevIndex(t *testing.T) {
+	tests := map[string]int{"sda": 0, "vdb": 1, "hdc": 2, "sdz": 25, "sdaa": 26}
+	for dev, want := range tests {
+		got, ok := targetDevIndex(dev)
+		if !ok || got != want {
+			t.Errorf("targetDevIndex(%q) = %d, %t, expected %d", dev, got, ok, want)
+		}
+	}
+
+	if _, ok := targetDevIndex("nvme0n1"); ok {
+		t.Errorf("targetDevIndex(%q) is expected to fail", "nvme0n1")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vmxDiskBuses is an order of disk buses in which virt-v2v converts disks of VMX file,
+// disks of a bus are ordered by controller and unit. Converted disks in the domain XML
+// returned by getDisks are in the same order.
+var vmxDiskBuses = []string{"scsi", "nvme", "sata", "ide"}
+
+// vmxDeviceIDRegexp matches a disk device ID like scsi0:1.
+var vmxDeviceIDRegexp = regexp.MustCompile(`^(scsi|nvme|sata|ide)(\d+):(\d+)$`)
+
+// vmxDeviceID is a parsed disk device ID like scsi0:1.
+type vmxDeviceID struct {
+	Bus        string
+	Controller int
+	Unit       int
+}
+
+func parseVMXDeviceID(id string) (vmxDeviceID, bool) {
+	m := vmxDeviceIDRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(id)))
+	if m == nil {
+		return vmxDeviceID{}, false
+	}
+
+	controller, _ := strconv.Atoi(m[2])
+	unit, _ := strconv.Atoi(m[3])
+
+	return vmxDeviceID{
+		Bus:        m[1],
+		Controller: controller,
+		Unit:       unit,
+	}, true
+}
+
+func (id vmxDeviceID) busOrder() int {
+	for i, bus := range vmxDiskBuses {
+		if bus == id.Bus {
+			return i
+		}
+	}
+	return len(vmxDiskBuses)
+}
+
+// lessVMXDevice returns true if disk device a is converted by virt-v2v before device b.
+func lessVMXDevice(a, b string) bool {
+	idA, okA := parseVMXDeviceID(a)
+	idB, okB := parseVMXDeviceID(b)
+	if !okA || !okB {
+		return okA && !okB
+	}
+
+	if idA.busOrder() != idB.busOrder() {
+		return idA.busOrder() < idB.busOrder()
+	}
+	if idA.Controller != idB.Controller {
+		return idA.Controller < idB.Controller
+	}
+	return idA.Unit < idB.Unit
+}
+
+// sortDisksByDevice sorts disks in the order they are converted by virt-v2v.
+func sortDisksByDevice(disks []Disk) {
+	sort.SliceStable(disks, func(i, j int) bool {
+		return lessVMXDevice(disks[i].Device, disks[j].Device)
+	})
+}
+
+// selectPrimaryDisk returns an index of the boot disk of disks sorted by sortDisksByDevice.
+// The disk is taken from bios.bootOrder and bios.hddOrder, the first disk is used if boot order is not set.
+func selectPrimaryDisk(v VMXFile, disks []Disk) int {
+	var candidates []string
+	for _, item := range splitVMXList(v.BootOrder) {
+		if item == "hdd" {
+			candidates = append(candidates, splitVMXList(v.HDDOrder)...)
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	candidates = append(candidates, splitVMXList(v.HDDOrder)...)
+
+	for _, c := range candidates {
+		for i, d := range disks {
+			if strings.EqualFold(d.Device, c) {
+				log.Printf("virtual server %q primary disk is %s by boot order", v.Name, d.Device)
+				return i
+			}
+		}
+	}
+
+	return 0
+}
+
+func splitVMXList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// applyPrimaryDiskDevice makes the disk of PrimaryDiskDevice primary if it was changed in the plan.
+// It's not applied to a virtual server which is already created.
+func (vs *VirtualServer) applyPrimaryDiskDevice() error {
+	if vs.PrimaryDiskDevice == "" || vs.PrimaryDisk == nil || strings.EqualFold(vs.PrimaryDisk.Device, vs.PrimaryDiskDevice) {
+		return nil
+	}
+
+	if vs.CurrentImportState() != ImportStatePlanned {
+		return fmt.Errorf("virtual server %q primary disk device can't be changed to %s, it's already created",
+			vs.OriginName, vs.PrimaryDiskDevice)
+	}
+
+	for i, d := range vs.AdditionalDisks {
+		if !strings.EqualFold(d.Device, vs.PrimaryDiskDevice) {
+			continue
+		}
+
+		previous := *vs.PrimaryDisk
+		previous.DiskOfferID = d.DiskOfferID
+
+		primary := d
+		primary.DiskOfferID = 0
+
+		vs.AdditionalDisks[i] = previous
+		sortDisksByDevice(vs.AdditionalDisks)
+
+		vs.PrimaryDisk = &primary
+		vs.PrimaryDiskSourcePath = primary.SourcePath
+		vs.CustomPlan.Params.Disk = primary.Size
+
+		log.Printf("virtual server %q primary disk is changed to %s", vs.OriginName, primary.Device)
+		return nil
+	}
+
+	return fmt.Errorf("virtual server %q has no disk %s to make it primary", vs.OriginName, vs.PrimaryDiskDevice)
+}
+
+// disksInConversionOrder returns all disks of the virtual server with destination paths in the order
+// they are converted by virt-v2v.
+func (vs VirtualServer) disksInConversionOrder() []Disk {
+	primary := Disk{
+		SourcePath: vs.PrimaryDiskSourcePath,
+	}
+	if vs.PrimaryDisk != nil {
+		primary = *vs.PrimaryDisk
+	}
+	primary.DestinationPath = vs.PrimaryDiskDestinationPath
+
+	disks := append([]Disk{primary}, vs.AdditionalDisks...)
+
+	for _, d := range disks {
+		// Plans created before disks ordering have the primary disk first.
+		if d.Device == "" {
+			return disks
+		}
+	}
+
+	sortDisksByDevice(disks)
+	return disks
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+)
+
+func diskDevices(disks []Disk) []string {
+	devices := make([]string, 0, len(disks))
+	for _, d := range disks {
+		devices = append(devices, d.Device)
+	}
+	return devices
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSortDisksByDevice(t *testing.T) {
+	disks := []Disk{
+		{Device: "ide0:0"},
+		{Device: "sata0:1"},
+		{Device: "scsi1:0"},
+		{Device: "nvme0:0"},
+		{Device: "scsi0:10"},
+		{Device: "scsi0:2"},
+		{Device: "unknown"},
+		{Device: "SATA0:0"},
+	}
+
+	sortDisksByDevice(disks)
+
+	want := []string{"scsi0:2", "scsi0:10", "scsi1:0", "nvme0:0", "SATA0:0", "sata0:1", "ide0:0", "unknown"}
+	if got := diskDevices(disks); !equalStrings(got, want) {
+		t.Errorf("disks are sorted as %v, expected %v", got, want)
+	}
+}
+
+func TestSelectPrimaryDisk(t *testing.T) {
+	disks := []Disk{{Device: "scsi0:0"}, {Device: "scsi0:1"}, {Device: "sata0:0"}}
+
+	tests := []struct {
+		name string
+		vmx  VMXFile
+		want int
+	}{
+		{name: "no boot order", want: 0},
+		{name: "hdd order", vmx: VMXFile{BootOrder: "cdrom,hdd", HDDOrder: "sata0:0"}, want: 2},
+		{name: "boot order device", vmx: VMXFile{BootOrder: " SCSI0:1 , cdrom"}, want: 1},
+		{name: "hdd order without boot order", vmx: VMXFile{HDDOrder: "scsi0:1,scsi0:0"}, want: 1},
+		{name: "unknown device", vmx: VMXFile{BootOrder: "ide0:0"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectPrimaryDisk(tt.vmx, disks); got != tt.want {
+				t.Errorf("primary disk is %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPrimaryDiskDevice(t *testing.T) {
+	vs := VirtualServer{
+		OriginName:            "vm",
+		PrimaryDiskSourcePath: "/vmfs/volumes/ds1/vm/vm.vmdk",
+		PrimaryDiskDevice:     "scsi0:2",
+		PrimaryDisk:           &Disk{SourcePath: "/vmfs/volumes/ds1/vm/vm.vmdk", Device: "scsi0:0", Size: 10},
+		AdditionalDisks: []Disk{
+			{SourcePath: "/vmfs/volumes/ds1/vm/vm_1.vmdk", Device: "scsi0:1", Size: 20, DiskOfferID: 3},
+			{SourcePath: "/vmfs/volumes/ds1/vm/vm_2.vmdk", Device: "scsi0:2", Size: 30, DiskOfferID: 4},
+		},
+	}
+
+	if err := vs.applyPrimaryDiskDevice(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if vs.PrimaryDisk.Device != "scsi0:2" || vs.PrimaryDisk.DiskOfferID != 0 {
+		t.Errorf("unexpected primary disk %+v", *vs.PrimaryDisk)
+	}
+	if vs.PrimaryDiskSourcePath != "/vmfs/volumes/ds1/vm/vm_2.vmdk" || vs.CustomPlan.Params.Disk != 30 {
+		t.Errorf("primary disk source path %q and plan disk size %d are not updated", vs.PrimaryDiskSourcePath, vs.CustomPlan.Params.Disk)
+	}
+	if got := diskDevices(vs.AdditionalDisks); !equalStrings(got, []string{"scsi0:0", "scsi0:1"}) {
+		t.Errorf("additional disks are %v", got)
+	}
+	if vs.AdditionalDisks[0].DiskOfferID != 4 {
+		t.Errorf("previous primary disk offer ID is %d, expected 4", vs.AdditionalDisks[0].DiskOfferID)
+	}
+
+	vs.PrimaryDiskDevice = "scsi0:5"
+	if err := vs.applyPrimaryDiskDevice(); err == nil {
+		t.Errorf("expected error for missing disk")
+	}
+
+	vs.PrimaryDiskDevice = "scsi0:1"
+	vs.VirtualServerID = 10
+	if err := vs.applyPrimaryDiskDevice(); err == nil {
+		t.Errorf("expected error for created virtual server")
+	}
+}
+
+func TestDisksInConversionOrder(t *testing.T) {
+	vs := VirtualServer{
+		PrimaryDiskSourcePath:      "/vmfs/volumes/ds1/vm/vm_1.vmdk",
+		PrimaryDiskDestinationPath: "/var/lib/libvirt/images/1",
+		PrimaryDisk:                &Disk{SourcePath: "/vmfs/volumes/ds1/vm/vm_1.vmdk", Device: "sata0:0"},
+		AdditionalDisks: []Disk{
+			{SourcePath: "/vmfs/volumes/ds1/vm/vm.vmdk", Device: "scsi0:0", DestinationPath: "/var/lib/libvirt/images/1-1"},
+		},
+	}
+
+	disks := vs.disksInConversionOrder()
+	if got := diskDevices(disks); !equalStrings(got, []string{"scsi0:0", "sata0:0"}) {
+		t.Errorf("disks are in order %v", got)
+	}
+	if disks[1].DestinationPath != "/var/lib/libvirt/images/1" {
+		t.Errorf("primary disk destination path is %q", disks[1].DestinationPath)
+	}
+
+	// Plans created before disks ordering have no devices.
+	vs.PrimaryDisk = nil
+	vs.AdditionalDisks[0].Device = ""
+	disks = vs.disksInConversionOrder()
+	if disks[0].SourcePath != vs.PrimaryDiskSourcePath {
+		t.Errorf("primary disk is expected first, got %q", disks[0].SourcePath)
+	}
+}
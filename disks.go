@@ -8,11 +8,16 @@ import (
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 	"github.com/solusio/import-vmware/command"
 	"github.com/solusio/import-vmware/common"
+	"github.com/solusio/import-vmware/goroutine"
 	"github.com/solusio/solus-go-sdk"
+	"log"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,104 +25,413 @@ const (
 	DeviceTypeDisk = "disk"
 )
 
-func importDisks(sourceIP string, plan ImportPlan) error {
-	// virt-v2v \
-	// -i vmx -it ssh \
-	// "ssh://root@192.168.192.168/vmfs/volumes/datastore1/wind2k35/wind2k35.vmx" \
-	// -o local -of qcow2 -os /var/lib/libvirt/images/123/
+type importDisksOptions struct {
+	// SourceHosts are source hosts by host names, virtual servers without source host
+	// are imported from DefaultSourceHost.
+	SourceHosts       map[string]*sourceHost
+	DefaultSourceHost string
+	SourceSSHUser     string
+	// SSHPassword is passed to virt-v2v when password authentication is used for the source host.
+	SSHPassword string
+	RunningVM   runningVMOptions
+	// UncleanShutdown is what to do with a Windows virtual server which was not shut down cleanly.
+	UncleanShutdown string
+	// ApplyMACAddresses overrides MAC addresses in the libvirt domain, it's not persistent in SolusVM 2.
+	ApplyMACAddresses  bool
+	ImportPlanFilePath string
+	VMDir              string
+
+	// Parallel is a number of virtual servers imported concurrently.
+	Parallel int
+	// ParallelPerSourceHost limits concurrent imports from the same source host, 0 means no limit.
+	ParallelPerSourceHost int
+	// ParallelPerDatastore limits concurrent imports to the same destination datastore, 0 means no limit.
+	ParallelPerDatastore int
+}
 
-	for _, vs := range plan.VirtualServers {
-		destinationPath := filepath.Dir(vs.PrimaryDiskDestinationPath)
-		importedXMLPath := filepath.Join(destinationPath, vs.OriginName+".xml")
-		if !common.IsExists(importedXMLPath) {
-			args := []string{
-				"-i", "vmx",
-				"-it", "ssh",
-				fmt.Sprintf("ssh://root@%s%s", sourceIP, vs.VMXFilePath),
-				"-o", "local",
-				"-of", "qcow2",
-				"-os", destinationPath,
-			}
+// importDisks imports disks of virtual servers of the plan. Failure of a virtual server
+// does not stop import of others, all errors are returned at the end.
+func importDisks(opts importDisksOptions, plan ImportPlan) error {
+	journal := newImportJournal(opts.ImportPlanFilePath, &plan)
 
-			if err := command.DefaultCommander.Build("virt-v2v", args...).Exec(); err != nil {
-				return err
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	sourceHostLimiter := newKeyedLimiter(opts.ParallelPerSourceHost)
+	datastoreLimiter := newKeyedLimiter(opts.ParallelPerDatastore)
+
+	queue := make(chan int)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		goroutine.Run(func() {
+			defer wg.Done()
+
+			for i := range queue {
+				vs := journal.VirtualServer(i)
+
+				// Limiters are always acquired in the same order to avoid deadlocks.
+				releaseSourceHost := sourceHostLimiter.Acquire(opts.sourceHostName(vs))
+				releaseDatastore := datastoreLimiter.Acquire(destinationDatastore(vs))
+
+				err := importVirtualServerDisks(opts, plan.Settings, journal, i)
+
+				releaseDatastore()
+				releaseSourceHost()
+
+				if err == nil || errors.Is(err, errVirtualServerSkipped) {
+					continue
+				}
+
+				if err := journal.Fail(i, err); err != nil {
+					log.Printf("failed to save virtual server %q import state: %s", vs.OriginName, err)
+				}
+
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("import virtual server %q: %w", vs.OriginName, err))
+				mu.Unlock()
 			}
+		})
+	}
+
+	for i, vs := range plan.VirtualServers {
+		if !vs.MatchesVMDir(opts.VMDir) {
+			continue
 		}
 
-		_ = command.DefaultCommander.Build("virsh", "destroy", vs.VirtualServerUUID).Exec()
+		if ImportStateDone.IsCompleted(vs.CurrentImportState()) {
+			log.Printf("virtual server %q is already imported, skip it", vs.OriginName)
+			continue
+		}
+
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// sourceHostName returns a host virtual server disks are imported from.
+func (o importDisksOptions) sourceHostName(vs VirtualServer) string {
+	if vs.SourceHost != "" {
+		return vs.SourceHost
+	}
+	return o.DefaultSourceHost
+}
+
+// sourceHost returns a source host of the virtual server.
+func (o importDisksOptions) sourceHost(vs VirtualServer) (*sourceHost, error) {
+	name := o.sourceHostName(vs)
+	host, ok := o.SourceHosts[name]
+	if !ok {
+		return nil, fmt.Errorf("source host %q is not connected", name)
+	}
+	return host, nil
+}
+
+// destinationDatastore returns a storage directory where virtual server disks are placed,
+// like /var/lib/libvirt/images for /var/lib/libvirt/images/123/disk.
+func destinationDatastore(vs VirtualServer) string {
+	return filepath.Dir(filepath.Dir(vs.PrimaryDiskDestinationPath))
+}
+
+// importVirtualServerDisks imports disks of i-th virtual server of the plan
+// starting from the last completed step.
+func importVirtualServerDisks(opts importDisksOptions, settings ImportSettings, journal *importJournal, i int) error {
+	vs := journal.VirtualServer(i)
+
+	if !ImportStateCreated.IsCompleted(vs.CurrentImportState()) {
+		return fmt.Errorf("virtual server is not created in SolusVM 2 yet")
+	}
+	if vs.PrimaryDiskDestinationPath == "" {
+		return fmt.Errorf("disk paths of virtual server are unknown, create virtual servers by the import plan again to get them")
+	}
+
+	destinationPath := filepath.Dir(vs.PrimaryDiskDestinationPath)
+	importedXMLPath := filepath.Join(destinationPath, vs.OriginName+".xml")
 
-		disks, err := getDisks(importedXMLPath)
+	// Plans created before the import journal was introduced have the converted
+	// domain XML as the only sign of completed conversion.
+	if vs.ImportState == "" && common.IsExists(importedXMLPath) {
+		if err := journal.SetState(i, ImportStateConverted); err != nil {
+			return err
+		}
+	}
+
+	if !ImportStateConverted.IsCompleted(journal.VirtualServer(i).CurrentImportState()) {
+		host, err := opts.sourceHost(vs)
 		if err != nil {
-			return fmt.Errorf("failed to get disks from %q: %s", importedXMLPath, err)
+			return err
 		}
 
-		if len(disks) == 0 {
-			return fmt.Errorf("zero disks found in %q", importedXMLPath)
+		if err := ensurePoweredOff(host.Node, vs, opts.RunningVM); err != nil {
+			return err
 		}
-		if err := os.Rename(disks[0].path, vs.PrimaryDiskDestinationPath); err != nil {
-			return fmt.Errorf("failed to move %q to %q: %s", disks[0].path, vs.PrimaryDiskDestinationPath, err)
+
+		if err := ensureCleanShutdown(host.Node, vs, opts.UncleanShutdown, opts.RunningVM.ShutdownTimeout); err != nil {
+			return err
 		}
 
-		if strings.Contains(vs.GuestOS, "windows") {
-			baseURL, err := url.Parse(plan.Settings.APIURL)
-			if err != nil {
-				return fmt.Errorf("parse api url %q: %w", plan.Settings.APIURL, err)
-			}
+		if err := prepareSnapshots(host.Node, vs); err != nil {
+			return err
+		}
 
-			client, err := solus.NewClient(baseURL, solus.APITokenAuthenticator{Token: plan.Settings.APIToken})
-			if err != nil {
-				return fmt.Errorf("create client: %w", err)
-			}
+		if err := journal.SetState(i, ImportStateConverting); err != nil {
+			return err
+		}
 
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-			data := solus.VirtualServerUpdateSettingsRequest{
-				DiskDriver: "sata",
-			}
-			if _, err := client.VirtualServers.UpdateSettings(ctx, vs.VirtualServerID, data); err != nil {
-				cancel()
-				return fmt.Errorf("update virtual server %d disk driver to sata: %w", vs.VirtualServerID, err)
-			}
-			cancel()
+		if err := convertVirtualServer(opts, host, vs, destinationPath); err != nil {
+			return err
 		}
 
-		if len(vs.AdditionalDisks) > 0 {
-			if len(disks) == 1 {
-				return fmt.Errorf("virtual server %q additional disks not found in %q, expected additional disks count is %d",
-					vs.OriginName,
-					importedXMLPath,
-					len(vs.AdditionalDisks))
-			}
+		if err := journal.SetState(i, ImportStateConverted); err != nil {
+			return err
+		}
+	}
 
-			additionalDisks := disks[1:]
+	if !ImportStateDisksPlaced.IsCompleted(journal.VirtualServer(i).CurrentImportState()) {
+		if err := placeDisks(vs, importedXMLPath); err != nil {
+			return err
+		}
 
-			if len(additionalDisks) != len(vs.AdditionalDisks) {
-				return fmt.Errorf("virtual server %q number of additional disks in %q is %d, but %d expected",
-					vs.OriginName,
-					importedXMLPath,
-					len(additionalDisks),
-					len(vs.AdditionalDisks))
+		if err := journal.SetState(i, ImportStateDisksPlaced); err != nil {
+			return err
+		}
+	}
+
+	if !ImportStateSettingsUpdated.IsCompleted(journal.VirtualServer(i).CurrentImportState()) {
+		if err := updateVirtualServerSettings(settings, vs, importedXMLPath); err != nil {
+			return err
+		}
+
+		if opts.ApplyMACAddresses {
+			if err := applyMACAddresses(settings, vs); err != nil {
+				return err
 			}
+		} else {
+			reportMACAddresses(vs)
+		}
 
-			for i, disk := range additionalDisks {
-				if err := os.Rename(disk.path, vs.AdditionalDisks[i].DestinationPath); err != nil {
-					return fmt.Errorf("failed to move virtual server %q disk %q to %q: %s",
-						vs.OriginName,
-						disk.path,
-						vs.AdditionalDisks[i].DestinationPath,
-						err)
-				}
+		if err := journal.SetState(i, ImportStateSettingsUpdated); err != nil {
+			return err
+		}
+	}
+
+	return journal.SetState(i, ImportStateDone)
+}
+
+// convertVirtualServer converts virtual server disks with virt-v2v to destinationPath. Disks which
+// virt-v2v can't read over SSH are converted from a temporary clone, it's removed after conversion.
+func convertVirtualServer(opts importDisksOptions, host *sourceHost, vs VirtualServer, destinationPath string) error {
+	vmxFilePath := vs.VMXFilePath
+	if vs.needsTemporaryClone() {
+		clone, err := cloneVirtualServer(host.Node, vs)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := clone.Remove(); err != nil {
+				log.Printf("failed to remove temporary clone of virtual server %q: %s", vs.OriginName, err)
 			}
+		}()
+		vmxFilePath = clone.VMXFilePath
+	}
+
+	// virt-v2v \
+	// -i vmx -it ssh \
+	// "ssh://root@192.168.192.168/vmfs/volumes/datastore1/wind2k35/wind2k35.vmx" \
+	// -o local -of qcow2 -os /var/lib/libvirt/images/123/
+	args := []string{
+		"-i", "vmx",
+		"-it", "ssh",
+		virtV2VSourceURL(host, opts.SourceSSHUser, vmxFilePath),
+		"-o", "local",
+		"-of", "qcow2",
+		"-os", destinationPath,
+	}
+
+	if opts.SSHPassword != "" {
+		passwordFile, err := writePasswordFile(opts.SSHPassword)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = os.Remove(passwordFile) }()
+
+		args = append(args, "-ip", passwordFile)
+	}
+
+	return command.DefaultCommander.Build("virt-v2v", args...).Exec()
+}
+
+// virtV2VSourceURL returns URL of the VMX file on the source host for virt-v2v like
+// ssh://root@192.168.192.168:2222/vmfs/volumes/datastore1/test%20vm%20%281%29/test%20vm%20%281%29.vmx.
+// The path is escaped, so spaces, quotes, parentheses and unicode characters in VM names are kept.
+func virtV2VSourceURL(host *sourceHost, user, vmxFilePath string) string {
+	address := host.SSHHost
+	switch {
+	case host.SSHPort != 0 && host.SSHPort != 22:
+		address = net.JoinHostPort(address, strconv.Itoa(host.SSHPort))
+	case strings.Contains(address, ":"):
+		// IPv6 literal is bracketed in URL whatever the port is.
+		address = "[" + address + "]"
+	}
+
+	u := url.URL{
+		Scheme: "ssh",
+		User:   url.User(user),
+		Host:   address,
+		Path:   vmxFilePath,
+	}
+	return u.String()
+}
+
+// writePasswordFile writes password to a temporary file readable by the owner only,
+// virt-v2v reads input password from a file.
+func writePasswordFile(password string) (string, error) {
+	f, err := os.CreateTemp("", "import-vmware-password")
+	if err != nil {
+		return "", fmt.Errorf("create password file: %w", err)
+	}
+
+	if _, err := f.WriteString(password); err != nil {
+		common.CloseWrapper(f)
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("write password file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("close password file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// placeDisks moves converted disks to the virtual server disks paths.
+// Already moved disks are skipped, so it's safe to call it again after a failure.
+func placeDisks(vs VirtualServer, importedXMLPath string) error {
+	_ = command.DefaultCommander.Build("virsh", "destroy", vs.VirtualServerUUID).Exec()
+
+	disks, err := getDisks(importedXMLPath)
+	if err != nil {
+		return fmt.Errorf("failed to get disks from %q: %s", importedXMLPath, err)
+	}
+
+	if len(disks) == 0 {
+		return fmt.Errorf("zero disks found in %q", importedXMLPath)
+	}
+
+	// All disks are matched before moving any of them, so nothing is moved on ambiguity.
+	mappings, err := matchConvertedDisks(vs, disks, convertedDiskSize)
+	if err != nil {
+		return fmt.Errorf("virtual server %q disks in %q: %w", vs.OriginName, importedXMLPath, err)
+	}
+
+	for _, m := range mappings {
+		if err := moveDisk(m.Converted.path, m.Disk.DestinationPath); err != nil {
+			return fmt.Errorf("virtual server %q: %w", vs.OriginName, err)
 		}
 	}
 
 	return nil
 }
 
+func moveDisk(sourcePath, destinationPath string) error {
+	if !common.IsExists(sourcePath) && common.IsExists(destinationPath) {
+		log.Printf("disk %q is already moved to %q", sourcePath, destinationPath)
+		return nil
+	}
+
+	if err := os.Rename(sourcePath, destinationPath); err != nil {
+		return fmt.Errorf("failed to move %q to %q: %s", sourcePath, destinationPath, err)
+	}
+
+	return nil
+}
+
+// updateVirtualServerSettings updates SolusVM 2 virtual server settings required to boot imported disks.
+func updateVirtualServerSettings(settings ImportSettings, vs VirtualServer, importedXMLPath string) error {
+	driver, err := bootDiskDriver(vs, importedXMLPath)
+	if err != nil {
+		return err
+	}
+	if driver == "" {
+		return nil
+	}
+
+	client, err := newSolusClient(settings)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	data := solus.VirtualServerUpdateSettingsRequest{
+		DiskDriver: driver,
+	}
+	if _, err := client.VirtualServers.UpdateSettings(ctx, vs.VirtualServerID, data); err != nil {
+		return fmt.Errorf("update virtual server %d disk driver to %s: %w", vs.VirtualServerID, driver, err)
+	}
+
+	return nil
+}
+
+// bootDiskDriver returns a disk driver the guest can boot with, it's empty if the default one fits.
+// virt-v2v keeps IDE or SATA bus of the primary disk if the guest has no VirtIO drivers.
+func bootDiskDriver(vs VirtualServer, importedXMLPath string) (solus.DiskDriver, error) {
+	disks, err := getDisks(importedXMLPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get disks from %q: %w", importedXMLPath, err)
+	}
+
+	return bootDiskDriverOf(vs, disks, convertedDiskSize)
+}
+
+// bootDiskDriverOf returns a disk driver by the bus of the converted primary disk, which is matched
+// the same way as on disks placement.
+func bootDiskDriverOf(vs VirtualServer, disks []domainDisk, sizeOf func(domainDisk, Disk) (int64, error)) (solus.DiskDriver, error) {
+	mappings, err := matchConvertedDisks(vs, disks, sizeOf)
+	if err != nil {
+		return "", fmt.Errorf("virtual server %q disks: %w", vs.OriginName, err)
+	}
+
+	bus := ""
+	for _, m := range mappings {
+		if m.Disk.SourcePath == vs.PrimaryDiskSourcePath {
+			bus = m.Converted.bus
+			break
+		}
+	}
+	if bus == "" {
+		return "", fmt.Errorf("virtual server %q primary disk %q is not found among converted disks", vs.OriginName, vs.PrimaryDiskSourcePath)
+	}
+
+	switch {
+	case bus == "ide":
+		return solus.DiskDriverIDE, nil
+	case bus == "sata" || isWindowsGuestOS(vs.GuestOS):
+		// Windows is booted with SATA first, VirtIO drivers are installed on the first boot.
+		return solus.DiskDriverSATA, nil
+	default:
+		return "", nil
+	}
+}
+
 type domainDisk struct {
 	// Guest disk device name.
 	// For example for `<target dev='vda' bus='scsi'/>` it will contains `vda`.
 	device string
 
+	// Guest disk bus chosen by virt-v2v.
+	// For example for `<target dev='vda' bus='virtio'/>` it will contains `virtio`.
+	bus string
+
 	// Driver type of the disk.
 	// Maybe one of supported but in our case it should be QCOW2 or RAW.
 	imageFormat string
@@ -162,8 +476,13 @@ func buildDisks(disks []libvirtxml.DomainDisk) ([]domainDisk, error) {
 			return nil, err
 		}
 
+		if disk.Target == nil {
+			return nil, fmt.Errorf("disk %q has no target", diskPath)
+		}
+
 		d := domainDisk{
 			device:      disk.Target.Dev,
+			bus:         disk.Target.Bus,
 			path:        diskPath,
 			imageFormat: disk.Driver.Type,
 		}
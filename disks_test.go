@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/solusio/solus-go-sdk"
+	"testing"
+)
+
+func TestVirtV2VSourceURL(t *testing.T) {
+	tests := []struct {
+		name string
+		host sourceHost
+		path string
+		want string
+	}{
+		{
+			name: "default port",
+			host: sourceHost{SSHHost: "192.168.192.168", SSHPort: 22},
+			path: "/vmfs/volumes/datastore1/vm/vm.vmx",
+			want: "ssh://root@192.168.192.168/vmfs/volumes/datastore1/vm/vm.vmx",
+		},
+		{
+			name: "tunnel port",
+			host: sourceHost{SSHHost: "127.0.0.1", SSHPort: 2222},
+			path: "/vmfs/volumes/datastore1/vm/vm.vmx",
+			want: "ssh://root@127.0.0.1:2222/vmfs/volumes/datastore1/vm/vm.vmx",
+		},
+		{
+			name: "spaces and parentheses",
+			host: sourceHost{SSHHost: "192.168.192.168"},
+			path: "/vmfs/volumes/datastore1/test vm (1)/test vm (1).vmx",
+			want: "ssh://root@192.168.192.168/vmfs/volumes/datastore1/test%20vm%20%281%29/test%20vm%20%281%29.vmx",
+		},
+		{
+			name: "quotes",
+			host: sourceHost{SSHHost: "192.168.192.168"},
+			path: `/vmfs/volumes/datastore1/it's "vm"/vm.vmx`,
+			want: "ssh://root@192.168.192.168/vmfs/volumes/datastore1/it%27s%20%22vm%22/vm.vmx",
+		},
+		{
+			name: "unicode",
+			host: sourceHost{SSHHost: "192.168.192.168"},
+			path: "/vmfs/volumes/datastore1/вм/вм.vmx",
+			want: "ssh://root@192.168.192.168/vmfs/volumes/datastore1/%D0%B2%D0%BC/%D0%B2%D0%BC.vmx",
+		},
+		{
+			name: "IPv6 default port",
+			host: sourceHost{SSHHost: "fd00::1", SSHPort: 22},
+			path: "/vmfs/volumes/datastore1/vm/vm.vmx",
+			want: "ssh://root@[fd00::1]/vmfs/volumes/datastore1/vm/vm.vmx",
+		},
+		{
+			name: "IPv6",
+			host: sourceHost{SSHHost: "fd00::1", SSHPort: 2222},
+			path: "/vmfs/volumes/datastore1/vm/vm.vmx",
+			want: "ssh://root@[fd00::1]:2222/vmfs/volumes/datastore1/vm/vm.vmx",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := virtV2VSourceURL(&tt.host, "root", tt.path); got != tt.want {
+				t.Errorf("virtV2VSourceURL() = %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootDiskDriverOf(t *testing.T) {
+	sizes := map[string]int64{"/tmp/out/vm-sda": 10 << 30, "/tmp/out/vm-sdb": 20 << 30}
+	sizeOf := func(c domainDisk, _ Disk) (int64, error) {
+		return sizes[c.path], nil
+	}
+
+	converted := func(bus string, devices ...string) []domainDisk {
+		var disks []domainDisk
+		for i, dev := range devices {
+			disks = append(disks, domainDisk{device: dev, bus: bus, path: "/tmp/out/vm-sd" + string(rune('a'+i))})
+		}
+		return disks
+	}
+
+	// The primary disk is the second one in conversion order.
+	bootFromSecond := testTwoDiskVirtualServer()
+	bootFromSecond.PrimaryDisk.Device = "sata0:0"
+	bootFromSecond.PrimaryDisk.CapacityBytes = 20 << 30
+	bootFromSecond.AdditionalDisks[0].Device = "scsi0:0"
+	bootFromSecond.AdditionalDisks[0].CapacityBytes = 10 << 30
+
+	tests := []struct {
+		name    string
+		guestOS string
+		vs      *VirtualServer
+		disks   []domainDisk
+		want    solus.DiskDriver
+		wantErr bool
+	}{
+		{name: "virtio", guestOS: "ubuntu-64", disks: converted("virtio", "vda", "vdb")},
+		{name: "ide", guestOS: "ubuntu-64", disks: converted("ide", "hda", "hdb"), want: solus.DiskDriverIDE},
+		{name: "sata", guestOS: "ubuntu-64", disks: converted("sata", "sda", "sdb"), want: solus.DiskDriverSATA},
+		{name: "windows", guestOS: "windows2019srv-64", disks: converted("virtio", "vda", "vdb"), want: solus.DiskDriverSATA},
+		{name: "windows 2003", guestOS: "winNetStandard", disks: converted("virtio", "vda", "vdb"), want: solus.DiskDriverSATA},
+		{
+			name:    "primary disk is not first",
+			guestOS: "ubuntu-64",
+			vs:      &bootFromSecond,
+			disks:   []domainDisk{{device: "sda", bus: "scsi", path: "/tmp/out/vm-sda"}, {device: "sdb", bus: "sata", path: "/tmp/out/vm-sdb"}},
+			want:    solus.DiskDriverSATA,
+		},
+		{name: "missing disk", guestOS: "ubuntu-64", disks: converted("ide", "hda"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := testTwoDiskVirtualServer()
+			if tt.vs != nil {
+				vs = *tt.vs
+			}
+			vs.GuestOS = tt.guestOS
+
+			got, err := bootDiskDriverOf(vs, tt.disks, sizeOf)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("disk driver is %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
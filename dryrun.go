@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/solusio/solus-go-sdk"
+)
+
+// dryRunCreateVirtualServers validates the plan with SolusVM 2 API and prints create
+// requests without creating anything.
+func dryRunCreateVirtualServers(client *solus.Client, plan ImportPlan, recreate bool) error {
+	if err := plan.ValidateOnline(client, recreate); err != nil {
+		return fmt.Errorf("failed to validate import plan online: %w", err)
+	}
+
+	ipAllocator := newPrimaryIPAllocator(plan.Settings, plan)
+
+	for _, vsPlan := range plan.VirtualServers {
+		if vsPlan.VirtualServerID != 0 && !recreate {
+			fmt.Printf("Virtual server %q is already created as ID %d, skip it\n", vsPlan.Hostname, vsPlan.VirtualServerID)
+			continue
+		}
+
+		primaryIP, err := ipAllocator.primaryIP(vsPlan)
+		if err != nil {
+			return err
+		}
+		if vsPlan.PrimaryIP == nil && primaryIP != nil {
+			fmt.Printf("Virtual server %q primary IP %s would be picked from IP block of its primary interface\n", vsPlan.Hostname, *primaryIP)
+		}
+		vsPlan.PrimaryIP = primaryIP
+
+		b, err := json.MarshalIndent(buildVirtualServerCreateRequest(plan.Settings, vsPlan), "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode create request of virtual server %q: %w", vsPlan.Hostname, err)
+		}
+
+		fmt.Printf("Virtual server %q would be created with request:\n%s\n", vsPlan.Hostname, string(b))
+
+		mappings, err := vsPlan.networkMappings(plan.Settings)
+		if err != nil {
+			return err
+		}
+		for n, nic := range vsPlan.NetworkInterfaces {
+			fmt.Printf("  network interface %s (%s, %s) portgroup %q", nic.Device, nic.VirtualDev, nic.MacAddress, nic.NetworkName)
+			if n < len(mappings) {
+				fmt.Printf(" -> IP block %d, VLAN %d", mappings[n].IPBlockID, mappings[n].VLAN)
+				if n > 0 {
+					fmt.Printf(" (advisory, additional IP is allocated from any IP block of the compute resource)")
+				}
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
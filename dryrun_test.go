@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"github.com/solusio/solus-go-sdk"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns everything f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+
+	f()
+
+	_ = w.Close()
+	return <-out
+}
+
+func TestDryRunCreateVirtualServers(t *testing.T) {
+	fb := solus.StorageType{Name: solus.StorageTypeNameFB}
+	api := newValidateOnlineTestAPI(t, fb, []solus.Storage{{ID: 1, Type: fb, FreeSpace: 100}})
+
+	plan := testValidateOnlinePlan(api.settings())
+	created := plan.VirtualServers[0]
+	created.Hostname = "created"
+	created.VirtualServerID = 42
+	plan.VirtualServers = append(plan.VirtualServers, created)
+	path := testImportPlanFile(t, *plan)
+
+	var err error
+	out := captureStdout(t, func() {
+		err = createVirtualServers(api.settings(), path, createVirtualServersOptions{DryRun: true})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, want := range []string{
+		`Virtual server "vm" would be created with request:`,
+		`"name": "vm"`,
+		`"compute_resource": 1`,
+		`Virtual server "created" is already created as ID 42, skip it`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `Virtual server "created" would be created`) {
+		t.Errorf("already created virtual server is printed as created:\n%s", out)
+	}
+
+	api.mu.Lock()
+	for _, r := range api.requests {
+		if !strings.HasPrefix(r, "GET ") {
+			t.Errorf("unexpected request %q", r)
+		}
+	}
+	api.mu.Unlock()
+
+	saved, err := loadImportPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.VirtualServers[0].VirtualServerID != 0 {
+		t.Errorf("virtual server ID %d is saved to the plan", saved.VirtualServers[0].VirtualServerID)
+	}
+}
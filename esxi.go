@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/solusio/import-vmware/ssh"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const esxiVolumesPath = "/vmfs/volumes"
+
+// errNotRegistered is returned when virtual machines of the host are listed and none of them has the VMX file.
+var errNotRegistered = errors.New("virtual machine is not registered on the host")
+
+// esxiVM is a virtual machine registered on ESXi host.
+type esxiVM struct {
+	ID   int
+	Name string
+	// VMXFilePath is a full path to VMX file like /vmfs/volumes/datastore1/testvm/testvm.vmx.
+	VMXFilePath string
+}
+
+// getAllVMsLineRegexp matches `vim-cmd vmsvc/getallvms` output line like
+// `1      testvm   [datastore1] testvm/testvm.vmx   debian12_64Guest   vmx-19`.
+var getAllVMsLineRegexp = regexp.MustCompile(`^(\d+)\s+(.*?)\s+\[(.+?)] (.+?\.vmx)(\s|$)`)
+
+func getESXiVMs(node ssh.NodeConnection) ([]esxiVM, error) {
+	out, err := node.Exec("vim-cmd vmsvc/getallvms")
+	if err != nil {
+		return nil, fmt.Errorf("get all vms %s: %w", string(out), err)
+	}
+
+	return parseESXiVMs(out)
+}
+
+// parseESXiVMs parses `vim-cmd vmsvc/getallvms` output, lines which are not a virtual machine
+// like the header and annotation continuation lines are skipped.
+func parseESXiVMs(out []byte) ([]esxiVM, error) {
+	var vms []esxiVM
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := getAllVMsLineRegexp.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+
+		vms = append(vms, esxiVM{
+			ID:          id,
+			Name:        m[2],
+			VMXFilePath: path.Join(esxiVolumesPath, m[3], m[4]),
+		})
+	}
+
+	return vms, scanner.Err()
+}
+
+// esxiVMIndex looks up virtual machines registered on ESXi host by their VMX file paths.
+type esxiVMIndex struct {
+	node ssh.NodeConnection
+	vms  []esxiVM
+
+	// resolved maps resolved VMX file paths of registered virtual machines to their IDs,
+	// it's filled on the first lookup which can't be matched by path as is.
+	resolved map[string]int
+}
+
+// newESXiVMIndex gets virtual machines registered on the host, the index may be used for any
+// number of lookups.
+func newESXiVMIndex(node ssh.NodeConnection) (*esxiVMIndex, error) {
+	vms, err := getESXiVMs(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esxiVMIndex{node: node, vms: vms}, nil
+}
+
+// findESXiVMID returns ESXi VM ID of a virtual machine by its VMX file path.
+func findESXiVMID(node ssh.NodeConnection, vmxFilePath string) (int, error) {
+	idx, err := newESXiVMIndex(node)
+	if err != nil {
+		return 0, err
+	}
+
+	return idx.lookup(vmxFilePath)
+}
+
+// lookup returns ESXi VM ID of a virtual machine by its VMX file path. errNotRegistered is returned
+// only if the path is resolved and no registered virtual machine has it, other errors mean the
+// registration is unknown.
+func (idx *esxiVMIndex) lookup(vmxFilePath string) (int, error) {
+	for _, vm := range idx.vms {
+		if vm.VMXFilePath == vmxFilePath {
+			return vm.ID, nil
+		}
+	}
+
+	// VMX file path may be specified with datastore UUID instead of its name,
+	// so try to resolve it on the host.
+	if err := idx.resolve(); err != nil {
+		return 0, err
+	}
+
+	out, err := idx.node.Exec(ssh.ShellCommand("readlink", "-f", vmxFilePath))
+	if err != nil {
+		return 0, fmt.Errorf("resolve vmx file path %q %s: %w", vmxFilePath, string(out), err)
+	}
+	if id, ok := idx.resolved[string(bytes.TrimSpace(out))]; ok {
+		return id, nil
+	}
+
+	return 0, fmt.Errorf("vmx file %q: %w", vmxFilePath, errNotRegistered)
+}
+
+// registered returns VMX file paths of virtual servers of the plan which are registered on the host.
+func (idx *esxiVMIndex) registered(plan ImportPlan) (map[string]bool, error) {
+	registered := map[string]bool{}
+	for _, vs := range plan.VirtualServers {
+		_, err := idx.lookup(vs.VMXFilePath)
+		if errors.Is(err, errNotRegistered) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("virtual server %q: %w", vs.OriginName, err)
+		}
+		registered[vs.VMXFilePath] = true
+	}
+	return registered, nil
+}
+
+// resolve resolves VMX file paths of all registered virtual machines with a single command.
+func (idx *esxiVMIndex) resolve() error {
+	if idx.resolved != nil || len(idx.vms) == 0 {
+		return nil
+	}
+
+	cmd := "for f in"
+	for _, vm := range idx.vms {
+		cmd += " " + ssh.QuoteShellArg(vm.VMXFilePath)
+	}
+	// A path which can't be resolved is printed as an empty line, so lines match virtual machines.
+	cmd += `; do readlink -f "$f" || echo; done`
+
+	out, err := idx.node.Exec(cmd)
+	if err != nil {
+		return fmt.Errorf("resolve vmx file paths %s: %w", string(out), err)
+	}
+
+	idx.resolved = parseResolvedVMXFilePaths(idx.vms, out)
+	return nil
+}
+
+// parseResolvedVMXFilePaths maps resolved VMX file paths, one per line in order of vms, to IDs.
+func parseResolvedVMXFilePaths(vms []esxiVM, out []byte) map[string]int {
+	resolved := make(map[string]int, len(vms))
+	lines := strings.Split(string(out), "\n")
+	for i, vm := range vms {
+		if i >= len(lines) {
+			break
+		}
+		if p := strings.TrimSpace(lines[i]); p != "" {
+			resolved[p] = vm.ID
+		}
+	}
+	return resolved
+}
+
+// consolidateSnapshots removes all snapshots of a virtual machine, so changes are
+// merged into its base disks.
+func consolidateSnapshots(node ssh.NodeConnection, vmxFilePath string) error {
+	id, err := findESXiVMID(node, vmxFilePath)
+	if err != nil {
+		return err
+	}
+
+	if out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/snapshot.removeall %d", id)); err != nil {
+		return fmt.Errorf("remove all snapshots of vm %d %s: %w", id, string(out), err)
+	}
+
+	return nil
+}
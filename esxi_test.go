@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseESXiVMs(t *testing.T) {
+	out := "Vmid         Name                          File                                  Guest OS          Version   Annotation\n" +
+		"1      testvm                 [datastore1] testvm/testvm.vmx                      debian12_64Guest        vmx-19    \n" +
+		"12     test vm (1)            [datastore 2] test vm (1)/test vm (1).vmx          windows2019srv_64Guest  vmx-19    Imported\n" +
+		"annotation continued on the next line\n" +
+		"13     db                     [datastore1] db/db.vmx\n"
+
+	vms, err := parseESXiVMs([]byte(out))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []esxiVM{
+		{ID: 1, Name: "testvm", VMXFilePath: "/vmfs/volumes/datastore1/testvm/testvm.vmx"},
+		{ID: 12, Name: "test vm (1)", VMXFilePath: "/vmfs/volumes/datastore 2/test vm (1)/test vm (1).vmx"},
+		{ID: 13, Name: "db", VMXFilePath: "/vmfs/volumes/datastore1/db/db.vmx"},
+	}
+	if !reflect.DeepEqual(vms, want) {
+		t.Errorf("virtual machines are %+v, expected %+v", vms, want)
+	}
+}
+
+func TestParseResolvedVMXFilePaths(t *testing.T) {
+	vms := []esxiVM{
+		{ID: 1, VMXFilePath: "/vmfs/volumes/datastore1/testvm/testvm.vmx"},
+		{ID: 12, VMXFilePath: "/vmfs/volumes/datastore 2/gone/gone.vmx"},
+		{ID: 13, VMXFilePath: "/vmfs/volumes/datastore1/db/db.vmx"},
+	}
+	// readlink of the second virtual machine failed, so its line is empty.
+	out := "/vmfs/volumes/5f1a-01/testvm/testvm.vmx\n\n/vmfs/volumes/5f1a-01/db/db.vmx\n"
+
+	want := map[string]int{
+		"/vmfs/volumes/5f1a-01/testvm/testvm.vmx": 1,
+		"/vmfs/volumes/5f1a-01/db/db.vmx":         13,
+	}
+	if got := parseResolvedVMXFilePaths(vms, []byte(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved vmx file paths are %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/solusio/import-vmware/ssh"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// FS is a read-only file system the import planner works on. It's like fs.FS with fs.ReadDirFS
+// and fs.StatFS, but names are absolute host paths like /vmfs/volumes/datastore1/vm/vm.vmx.
+type FS interface {
+	Open(name string) (fs.File, error)
+	// ReadDir returns entries of the directory sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	// ReadLink returns the destination of the symbolic link, it fails if name is not a link.
+	ReadLink(name string) (string, error)
+}
+
+// isExists returns true if the file exists in fsys.
+func isExists(fsys FS, name string) (bool, error) {
+	_, err := fsys.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// localFS is a file system of the local host.
+type localFS struct{}
+
+func (localFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (localFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadLink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// sftpFS is a file system of a remote host read over SFTP, so nothing is executed on the host.
+type sftpFS struct {
+	conn *ssh.Connection
+}
+
+func (s sftpFS) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, err := s.conn.Download(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Download streams the whole file, so it's cancelled when only a part of the file is read.
+	return &sftpFile{
+		ReadCloser: r,
+		info:       info,
+		cancel:     cancel,
+	}, nil
+}
+
+func (s sftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := s.conn.List(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (s sftpFS) Stat(name string) (fs.FileInfo, error) {
+	return s.conn.Stat(context.Background(), name)
+}
+
+func (s sftpFS) ReadLink(name string) (string, error) {
+	return s.conn.ReadLink(context.Background(), name)
+}
+
+type sftpFile struct {
+	io.ReadCloser
+	info   fs.FileInfo
+	cancel context.CancelFunc
+}
+
+func (f *sftpFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *sftpFile) Close() error {
+	f.cancel()
+	return f.ReadCloser.Close()
+}
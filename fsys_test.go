@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// memFS is an in-memory file system, keys are absolute paths of files. Parent directories
+// are synthesized like in fstest.MapFS. A file with fs.ModeSymlink mode is a symbolic link
+// to the path in its data, links are followed in every element of a path.
+type memFS map[string]*fstest.MapFile
+
+func (m memFS) Open(name string) (fs.File, error) {
+	return m.mapFS().Open(memFSName(m.resolve(name)))
+}
+
+func (m memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.mapFS().ReadDir(memFSName(m.resolve(name)))
+}
+
+func (m memFS) Stat(name string) (fs.FileInfo, error) {
+	return m.mapFS().Stat(memFSName(m.resolve(name)))
+}
+
+func (m memFS) ReadLink(name string) (string, error) {
+	name = path.Join(m.resolve(path.Dir(name)), path.Base(name))
+	f, ok := m[name]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(f.Data), nil
+}
+
+// resolve returns the absolute path with symbolic links followed.
+func (m memFS) resolve(name string) string {
+	resolved := "/"
+	for _, elem := range strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/") {
+		resolved = path.Join(resolved, elem)
+
+		f, ok := m[resolved]
+		if !ok || f.Mode&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		target := string(f.Data)
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(resolved), target)
+		}
+		resolved = target
+	}
+	return resolved
+}
+
+func (m memFS) mapFS() fstest.MapFS {
+	mapFS := make(fstest.MapFS, len(m))
+	for name, f := range m {
+		mapFS[memFSName(name)] = f
+	}
+	return mapFS
+}
+
+// memFSName converts an absolute path to a name valid for fs.FS.
+func memFSName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func memFile(data string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(data)}
+}
+
+func memLink(target string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0755}
+}
+
+func TestMemFSFollowsLinks(t *testing.T) {
+	fsys := memFS{
+		"/vmfs/volumes/5f1a-01/vm/vm.vmx": memFile(`displayName = "vm"`),
+		"/vmfs/volumes/datastore1":        memLink("/vmfs/volumes/5f1a-01"),
+	}
+
+	if _, err := fsys.Stat("/vmfs/volumes/datastore1/vm/vm.vmx"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	target, err := fsys.ReadLink("/vmfs/volumes/datastore1")
+	if err != nil || target != "/vmfs/volumes/5f1a-01" {
+		t.Errorf("link target is %q, %v", target, err)
+	}
+
+	if _, err := fsys.ReadLink("/vmfs/volumes/5f1a-01"); err == nil {
+		t.Errorf("expected error for directory")
+	}
+}
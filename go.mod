@@ -3,17 +3,17 @@ module github.com/solusio/import-vmware
 go 1.22.0
 
 require (
-	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0
 	github.com/klauspost/readahead v1.4.0
+	github.com/libvirt/libvirt-go-xml v7.4.0+incompatible
 	github.com/pkg/sftp v1.13.6
+	github.com/solusio/solus-go-sdk v0.0.0-20240531111439-a9f6da81f560
 	golang.org/x/crypto v0.22.0
+	golang.org/x/term v0.19.0
 )
 
 require (
 	github.com/digitalocean/go-libvirt v0.0.0-20240308204700-df736b2945cf // indirect
 	github.com/kr/fs v0.1.0 // indirect
-	github.com/libvirt/libvirt-go-xml v7.4.0+incompatible // indirect
-	github.com/solusio/solus-go-sdk v0.0.0-20240531111439-a9f6da81f560 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	gopkg.in/guregu/null.v4 v4.0.0 // indirect
 )
@@ -17,6 +17,7 @@ type Ethernet struct {
 	AddressType          EthernetAddressType `vmx:"addresstype,omitempty"`
 	LinkStatePropagation bool                `vmx:"linkstatepropagation.enable,omitempty"`
 	VNetwork             string              `vmx:"vnet,omitempty"`
+	NetworkName          string              `vmx:"networkname,omitempty"`
 }
 
 type EthernetAddressType string
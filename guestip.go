@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/solusio/import-vmware/ssh"
+	"github.com/solusio/solus-go-sdk"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GuestIP is an IPv4 address reported by VMware Tools of a running guest.
+type GuestIP struct {
+	IP string `json:"ip"`
+	// Device is a VMX device ID of the network interface the address is assigned to,
+	// it's empty if the interface is not found by MAC address.
+	Device string `json:"device,omitempty"`
+	// IPBlockID is an ID of SolusVM 2 IP block the address belongs to, it's empty if the address
+	// is out of all IP blocks or IP blocks are not checked.
+	IPBlockID int `json:"ip_block_id,omitempty"`
+}
+
+// guestNIC is a network interface of a guest reported by VMware Tools.
+type guestNIC struct {
+	mac string
+	ips []string
+}
+
+var quotedValueRegexp = regexp.MustCompile(`"([^"]*)"`)
+
+// parseGuestInfo parses `vim-cmd vmsvc/get.guest` output and returns the guest primary IP address
+// and network interfaces. Output is like:
+//
+//	(vim.vm.GuestInfo) {
+//	   ipAddress = "192.168.1.10",
+//	   net = (vim.vm.GuestInfo.NicInfo) [
+//	      (vim.vm.GuestInfo.NicInfo) {
+//	         network = "VM Network",
+//	         ipAddress = (string) [
+//	            "192.168.1.10",
+//	            "fe80::250:56ff:fe9a:1"
+//	         ],
+//	         macAddress = "00:50:56:9a:00:01",
+func parseGuestInfo(out string) (string, []guestNIC) {
+	var primary string
+	var nics []guestNIC
+	inIPList := false
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		if inIPList {
+			if strings.HasPrefix(line, "]") {
+				inIPList = false
+				continue
+			}
+			if m := quotedValueRegexp.FindStringSubmatch(line); m != nil {
+				nics[len(nics)-1].ips = append(nics[len(nics)-1].ips, m[1])
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "(vim.vm.GuestInfo.NicInfo) {"):
+			nics = append(nics, guestNIC{})
+		case len(nics) == 0 && strings.HasPrefix(line, "ipAddress = \""):
+			if m := quotedValueRegexp.FindStringSubmatch(line); m != nil {
+				primary = m[1]
+			}
+		case len(nics) > 0 && strings.HasPrefix(line, "ipAddress = (string) ["):
+			inIPList = true
+		case len(nics) > 0 && strings.HasPrefix(line, "macAddress = "):
+			if m := quotedValueRegexp.FindStringSubmatch(line); m != nil {
+				nics[len(nics)-1].mac = m[1]
+			}
+		}
+	}
+
+	return primary, nics
+}
+
+// isGuestIPv4 returns true for an IPv4 address which may be assigned to the imported virtual server.
+func isGuestIPv4(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// guestIPs returns IPv4 addresses of the guest in the order of the virtual server network interfaces.
+func (vs VirtualServer) guestIPs(primary string, nics []guestNIC) []GuestIP {
+	var ips []GuestIP
+	used := make([]bool, len(nics))
+
+	add := func(device string, nic guestNIC) {
+		for _, ip := range nic.ips {
+			if isGuestIPv4(ip) {
+				ips = append(ips, GuestIP{IP: ip, Device: device})
+			}
+		}
+	}
+
+	for _, iface := range vs.NetworkInterfaces {
+		for i, nic := range nics {
+			if !used[i] && nic.mac != "" && strings.EqualFold(nic.mac, iface.MacAddress) {
+				used[i] = true
+				add(iface.Device, nic)
+			}
+		}
+	}
+
+	for i, nic := range nics {
+		if !used[i] {
+			add("", nic)
+		}
+	}
+
+	if len(ips) == 0 && isGuestIPv4(primary) {
+		ips = append(ips, GuestIP{IP: primary})
+	}
+
+	return ips
+}
+
+// recordGuestIPs records IP addresses of running virtual servers reported by VMware Tools to the plan.
+func recordGuestIPs(node ssh.NodeConnection, vms *esxiVMIndex, plan *ImportPlan) error {
+	for i := range plan.VirtualServers {
+		vs := &plan.VirtualServers[i]
+		if vs.PowerState != PowerStateOn {
+			continue
+		}
+
+		id, err := vms.lookup(vs.VMXFilePath)
+		if err != nil {
+			continue
+		}
+
+		out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/get.guest %d", id))
+		if err != nil {
+			log.Printf("virtual server %q guest IP addresses are unknown: get guest info of vm %d %s: %s", vs.OriginName, id, string(out), err)
+			continue
+		}
+
+		vs.GuestIPs = vs.guestIPs(parseGuestInfo(string(out)))
+		if len(vs.GuestIPs) == 0 {
+			log.Printf("virtual server %q has no guest IPv4 addresses, VMware Tools may be not running", vs.OriginName)
+		}
+	}
+
+	return nil
+}
+
+// markGuestIPBlocks sets IDs of SolusVM 2 IP blocks guest IP addresses belong to and prefills primary IP
+// and number of additional IPv4 addresses which are not set yet. Only IPv4 range blocks are checked.
+func markGuestIPBlocks(plan *ImportPlan, blocks []solus.IPBlock) {
+	for i := range plan.VirtualServers {
+		vs := &plan.VirtualServers[i]
+
+		for j := range vs.GuestIPs {
+			ip := &vs.GuestIPs[j]
+
+			for _, b := range blocks {
+				if b.Type != solus.IPv4 || b.ListType != solus.IpBlockListTypeRange {
+					continue
+				}
+				if in, _ := inIPv4Range(b.From, b.To, ip.IP); in {
+					ip.IPBlockID = b.ID
+					break
+				}
+			}
+
+			if ip.IPBlockID == 0 {
+				log.Printf("virtual server %q guest IP %s is out of SolusVM 2 IP blocks", vs.OriginName, ip.IP)
+			}
+		}
+
+		vs.prefillGuestIPs()
+	}
+}
+
+// prefillGuestIPs sets primary IP and number of additional IPv4 addresses from guest IP addresses
+// which belong to SolusVM 2 IP blocks, since SolusVM 2 can't assign other addresses.
+func (vs *VirtualServer) prefillGuestIPs() {
+	var inBlock []string
+	for _, ip := range vs.GuestIPs {
+		if ip.IPBlockID != 0 {
+			inBlock = append(inBlock, ip.IP)
+		}
+	}
+
+	if len(inBlock) == 0 {
+		return
+	}
+
+	if vs.PrimaryIP == nil {
+		primaryIP := inBlock[0]
+		vs.PrimaryIP = &primaryIP
+	}
+	if vs.AdditionalIPv4 == nil && len(inBlock) > 1 {
+		additional := len(inBlock) - 1
+		vs.AdditionalIPv4 = &additional
+	}
+}
+
+// listIPBlocks returns all SolusVM 2 IP blocks.
+func listIPBlocks(settings ImportSettings) ([]solus.IPBlock, error) {
+	client, err := newSolusClient(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	defer cancel()
+
+	resp, err := client.IPBlocks.List(ctx, &solus.FilterIPBlocks{})
+	if err != nil {
+		return nil, fmt.Errorf("list IP blocks: %w", err)
+	}
+
+	var blocks []solus.IPBlock
+	for {
+		blocks = append(blocks, resp.Data...)
+		if !resp.Next(ctx) {
+			break
+		}
+	}
+	if err := resp.Err(); err != nil {
+		return nil, fmt.Errorf("list IP blocks: %w", err)
+	}
+
+	return blocks, nil
+}
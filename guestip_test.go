@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/solusio/solus-go-sdk"
+	"testing"
+)
+
+const testGuestInfo = `Guest information:
+
+(vim.vm.GuestInfo) {
+   toolsRunningStatus = "guestToolsRunning",
+   ipAddress = "10.0.0.5",
+   net = (vim.vm.GuestInfo.NicInfo) [
+      (vim.vm.GuestInfo.NicInfo) {
+         network = "DMZ",
+         ipAddress = (string) [
+            "192.168.1.10",
+            "fe80::250:56ff:fe9a:2"
+         ],
+         macAddress = "00:50:56:9a:00:02",
+      },
+      (vim.vm.GuestInfo.NicInfo) {
+         network = "VM Network",
+         ipAddress = (string) [
+            "10.0.0.5",
+            "10.0.0.6"
+         ],
+         macAddress = "00:50:56:9A:00:01",
+      }
+   ],
+}`
+
+func TestGuestIPsPrefill(t *testing.T) {
+	vs := VirtualServer{
+		OriginName: "vm",
+		NetworkInterfaces: []NetworkInterface{
+			{Device: "ethernet0", MacAddress: "00:50:56:9a:00:01"},
+			{Device: "ethernet1", MacAddress: "00:50:56:9a:00:02"},
+		},
+	}
+
+	vs.GuestIPs = vs.guestIPs(parseGuestInfo(testGuestInfo))
+
+	want := []GuestIP{
+		{IP: "10.0.0.5", Device: "ethernet0"},
+		{IP: "10.0.0.6", Device: "ethernet0"},
+		{IP: "192.168.1.10", Device: "ethernet1"},
+	}
+	if len(vs.GuestIPs) != len(want) {
+		t.Fatalf("expected guest IPs %v, got %v", want, vs.GuestIPs)
+	}
+	for i := range want {
+		if vs.GuestIPs[i] != want[i] {
+			t.Errorf("guest IP %d is %v, expected %v", i, vs.GuestIPs[i], want[i])
+		}
+	}
+
+	plan := ImportPlan{VirtualServers: []VirtualServer{vs}}
+	markGuestIPBlocks(&plan, []solus.IPBlock{
+		{ID: 7, Type: solus.IPv4, ListType: solus.IpBlockListTypeRange, From: "192.168.1.2", To: "192.168.1.254"},
+	})
+
+	got := plan.VirtualServers[0]
+	if got.PrimaryIP == nil || *got.PrimaryIP != "192.168.1.10" {
+		t.Errorf("expected primary IP of IP block 192.168.1.10, got %v", got.PrimaryIP)
+	}
+	if got.AdditionalIPv4 != nil {
+		t.Errorf("expected no additional IPv4 addresses, got %d", *got.AdditionalIPv4)
+	}
+}
+
+func TestGuestIPsOutOfIPBlocksAreNotPrefilled(t *testing.T) {
+	vs := VirtualServer{OriginName: "vm"}
+	vs.GuestIPs = vs.guestIPs(parseGuestInfo(testGuestInfo))
+
+	plan := ImportPlan{VirtualServers: []VirtualServer{vs}}
+	markGuestIPBlocks(&plan, nil)
+
+	if plan.VirtualServers[0].PrimaryIP != nil {
+		t.Errorf("expected no primary IP, got %s", *plan.VirtualServers[0].PrimaryIP)
+	}
+}
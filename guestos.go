@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// windowsGuestOSPattern matches VMware guest OS IDs of Windows like windows2019srv-64, winNetStandard,
+// winLonghorn, winVista or win7-64.
+const windowsGuestOSPattern = `/^win/`
+
+// isWindowsGuestOS returns true if VMX guestOS is a Windows one.
+func isWindowsGuestOS(guestOS string) bool {
+	ok, _ := matchPattern(windowsGuestOSPattern, guestOS)
+	return ok
+}
+
+// GuestOSRule maps virtual servers to OS image version by patterns. A pattern is a case-insensitive glob
+// like `windows2019srv*`, or a regular expression enclosed in slashes like `/^windows20\d\d/`.
+// Empty patterns match any virtual server, all set patterns must match.
+type GuestOSRule struct {
+	// Name is shown in the mapping report, the rule index is shown if it's empty.
+	Name string `json:"name,omitempty"`
+	// GuestOS is a pattern of VMX guestOS like windows2019srvNext-64.
+	GuestOS string `json:"guest_os,omitempty"`
+	// VMName is a pattern of VMX displayName.
+	VMName string `json:"vm_name,omitempty"`
+	// Annotation is a pattern of VMX annotation, the notes of the virtual machine.
+	Annotation       string `json:"annotation,omitempty"`
+	OSImageVersionID int    `json:"os_image_version_id,omitempty"`
+	// OSImage is an OS image name like "Ubuntu 22.04", it's resolved to ID with SolusVM 2 API.
+	OSImage string `json:"os_image,omitempty"`
+}
+
+// guestOSMatch is an OS image version of a virtual server and the rule it's chosen by.
+// OSImage is set instead of OSImageVersionID until OS image names are resolved.
+type guestOSMatch struct {
+	OSImageVersionID int
+	OSImage          string
+	Rule             string
+}
+
+func (m guestOSMatch) isMapped() bool {
+	return m.OSImageVersionID != 0 || m.OSImage != ""
+}
+
+// matchPattern returns true if s matches a glob or a regular expression enclosed in slashes.
+func matchPattern(pattern, s string) (bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+		return re.MatchString(s), nil
+	}
+
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return ok, nil
+}
+
+// validate returns an error if the rule maps to no OS image, such a rule would stop matching of next rules
+// and the default OS image, or if a pattern is invalid.
+func (r GuestOSRule) validate() error {
+	if r.OSImageVersionID == 0 && r.OSImage == "" {
+		return fmt.Errorf("neither os_image_version_id nor os_image is set")
+	}
+
+	for _, pattern := range []string{r.GuestOS, r.VMName, r.Annotation} {
+		if _, err := matchPattern(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if every set pattern of the rule matches the virtual server.
+func (r GuestOSRule) matches(vs VirtualServer) (bool, error) {
+	checks := []struct {
+		pattern string
+		value   string
+	}{
+		{r.GuestOS, vs.GuestOS},
+		{r.VMName, vs.OriginName},
+		{r.Annotation, vs.Annotation},
+	}
+
+	for _, c := range checks {
+		if c.pattern == "" {
+			continue
+		}
+
+		ok, err := matchPattern(c.pattern, c.value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// resolveGuestOS returns OS image version of the virtual server. Exact guest OS mapping is checked first,
+// then rules in order, then the default OS image version. The match is not mapped if nothing matches.
+func resolveGuestOS(defaults Defaults, vs VirtualServer) (guestOSMatch, error) {
+	if name := defaults.GuestOSToOSImage[vs.GuestOS]; name != "" {
+		return guestOSMatch{
+			OSImage: name,
+			Rule:    fmt.Sprintf("guest_os_to_os_image[%q]", vs.GuestOS),
+		}, nil
+	}
+
+	if id, ok := defaults.GuestOSToOSImageVersionID[vs.GuestOS]; ok && id != 0 {
+		return guestOSMatch{
+			OSImageVersionID: id,
+			Rule:             fmt.Sprintf("guest_os_to_os_image_version_id[%q]", vs.GuestOS),
+		}, nil
+	}
+
+	for i, r := range defaults.GuestOSRules {
+		ok, err := r.matches(vs)
+		if err != nil {
+			return guestOSMatch{}, fmt.Errorf("guest OS rule %d: %w", i, err)
+		}
+		if !ok {
+			continue
+		}
+
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("guest_os_rules[%d]", i)
+		}
+		return guestOSMatch{
+			OSImageVersionID: r.OSImageVersionID,
+			OSImage:          r.OSImage,
+			Rule:             name,
+		}, nil
+	}
+
+	if defaults.DefaultOSImage != "" {
+		return guestOSMatch{
+			OSImage: defaults.DefaultOSImage,
+			Rule:    "default_os_image",
+		}, nil
+	}
+
+	if defaults.DefaultOSImageVersionID != 0 {
+		return guestOSMatch{
+			OSImageVersionID: defaults.DefaultOSImageVersionID,
+			Rule:             "default_os_image_version_id",
+		}, nil
+	}
+
+	return guestOSMatch{}, nil
+}
+
+// printGuestOSReport prints OS image version and the matched rule of every virtual server.
+func printGuestOSReport(plan ImportPlan) {
+	for _, vs := range plan.VirtualServers {
+		fmt.Println(guestOSReportLine(plan.Settings.Defaults, vs))
+	}
+}
+
+// guestOSReportLine describes the OS image version the guest OS of the virtual server is mapped to.
+// OS image name is reported if it's not resolved to a version ID yet.
+func guestOSReportLine(defaults Defaults, vs VirtualServer) string {
+	m, err := resolveGuestOS(defaults, vs)
+	if err != nil || !m.isMapped() {
+		return fmt.Sprintf("Virtual server %q guest OS %q is not mapped to OS image version", vs.Hostname, vs.GuestOS)
+	}
+
+	target := fmt.Sprintf("OS image version %d", m.OSImageVersionID)
+	if m.OSImageVersionID == 0 {
+		target = fmt.Sprintf("OS image %q", m.OSImage)
+	}
+
+	return fmt.Sprintf("Virtual server %q guest OS %q is mapped to %s by %s", vs.Hostname, vs.GuestOS, target, m.Rule)
+}
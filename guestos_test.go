@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchPatternIsCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"windows2019srv*", "windows2019srvNext-64", true},
+		{"WINDOWS*", "windows9-64", true},
+		{"/^windows20\\d\\d/", "Windows2019srv-64", true},
+		{"/^WINDOWS/", "windows9-64", true},
+		{"/^windows/", "ubuntu-64", false},
+	}
+
+	for _, tt := range tests {
+		got, err := matchPattern(tt.pattern, tt.s)
+		if err != nil {
+			t.Fatalf("matchPattern(%q, %q): unexpected error: %s", tt.pattern, tt.s, err)
+		}
+		if got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %t, expected %t", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGuestOSRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    GuestOSRule
+		wantErr bool
+	}{
+		{name: "os image version ID", rule: GuestOSRule{GuestOS: "windows*", OSImageVersionID: 5}},
+		{name: "os image", rule: GuestOSRule{VMName: "/^web/", OSImage: "Ubuntu 22.04"}},
+		{name: "no os image", rule: GuestOSRule{GuestOS: "windows*"}, wantErr: true},
+		{name: "invalid regular expression", rule: GuestOSRule{GuestOS: "/(/", OSImageVersionID: 5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.validate()
+			if tt.wantErr && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestIsWindowsGuestOS(t *testing.T) {
+	tests := map[string]bool{
+		"windows2019srvNext-64": true,
+		"windows9-64":           true,
+		"winNetStandard":        true,
+		"winLonghorn64":         true,
+		"winVista":              true,
+		"win7-64":               true,
+		"ubuntu-64":             false,
+		"darwin-64":             false,
+		"":                      false,
+	}
+
+	for guestOS, want := range tests {
+		if got := isWindowsGuestOS(guestOS); got != want {
+			t.Errorf("isWindowsGuestOS(%q) = %t, expected %t", guestOS, got, want)
+		}
+	}
+}
+
+func TestGuestOSReportLine(t *testing.T) {
+	vs := VirtualServer{Hostname: "db", GuestOS: "ubuntu-64"}
+
+	tests := []struct {
+		name     string
+		defaults Defaults
+		want     string
+	}{
+		{
+			name:     "version ID",
+			defaults: Defaults{GuestOSToOSImageVersionID: map[string]int{"ubuntu-64": 3}},
+			want:     `Virtual server "db" guest OS "ubuntu-64" is mapped to OS image version 3 by guest_os_to_os_image_version_id["ubuntu-64"]`,
+		},
+		{
+			name:     "unresolved OS image name",
+			defaults: Defaults{GuestOSRules: []GuestOSRule{{Name: "ubuntu", GuestOS: "ubuntu*", OSImage: "Ubuntu 22.04"}}},
+			want:     `Virtual server "db" guest OS "ubuntu-64" is mapped to OS image "Ubuntu 22.04" by ubuntu`,
+		},
+		{
+			name: "not mapped",
+			want: `Virtual server "db" guest OS "ubuntu-64" is not mapped to OS image version`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := guestOSReportLine(tt.defaults, vs); got != tt.want {
+				t.Errorf("report line is\n%s\nexpected\n%s", got, tt.want)
+			}
+		})
+	}
+}
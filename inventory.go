@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// datastore is a VMware datastore mounted to /vmfs/volumes. Every datastore is a directory
+// named by UUID and a symlink to it named by datastore name.
+type datastore struct {
+	// Name is a datastore name like datastore1, it's UUID if datastore has no named link.
+	Name string
+	// UUID is a datastore UUID like 6523e3a5-2b1b4f6e-8a4e-000c29d5e0a1.
+	UUID string
+	// Path is a datastore path like /vmfs/volumes/datastore1.
+	Path string
+}
+
+// listDatastores returns all datastores mounted to volumesPath. A datastore is returned once
+// even if it's available both by UUID and by name.
+func listDatastores(fsys FS, volumesPath string) ([]datastore, error) {
+	entries, err := fsys.ReadDir(volumesPath)
+	if err != nil {
+		return nil, fmt.Errorf("read datastores directory %q: %w", volumesPath, err)
+	}
+
+	uuidToName := map[string]string{}
+	var uuids []string
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		if e.Type()&fs.ModeSymlink != 0 {
+			target, err := fsys.ReadLink(filepath.Join(volumesPath, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			uuid := filepath.Base(target)
+			if _, ok := uuidToName[uuid]; !ok {
+				uuidToName[uuid] = e.Name()
+			}
+			continue
+		}
+
+		if e.IsDir() {
+			uuids = append(uuids, e.Name())
+		}
+	}
+
+	datastores := make([]datastore, 0, len(uuids))
+	for _, uuid := range uuids {
+		name, ok := uuidToName[uuid]
+		if !ok {
+			name = uuid
+		}
+
+		datastores = append(datastores, datastore{
+			Name: name,
+			UUID: uuid,
+			Path: filepath.Join(volumesPath, name),
+		})
+	}
+
+	sort.Slice(datastores, func(i, j int) bool {
+		return datastores[i].Name < datastores[j].Name
+	})
+
+	return datastores, nil
+}
+
+// datastoreOf returns a datastore of storagePath, which is either a named link or a directory.
+func datastoreOf(fsys FS, storagePath string) datastore {
+	storagePath = filepath.Clean(storagePath)
+
+	ds := datastore{
+		Name: filepath.Base(storagePath),
+		UUID: filepath.Base(storagePath),
+		Path: storagePath,
+	}
+
+	// Not a symlink is an error too, so the path is treated as the datastore directory.
+	if target, err := fsys.ReadLink(storagePath); err == nil {
+		ds.UUID = filepath.Base(target)
+	}
+
+	return ds
+}
+
+// createHostImportPlan creates an import plan for virtual servers in storagePath of a host.
+// All datastores of the host are scanned if storagePath is empty.
+func createHostImportPlan(fsys FS, storagePath, vmName, snapshotMode string) (ImportPlan, error) {
+	if storagePath != "" {
+		return createImportPlan(fsys, datastoreOf(fsys, storagePath), vmName, snapshotMode)
+	}
+
+	datastores, err := listDatastores(fsys, esxiVolumesPath)
+	if err != nil {
+		return ImportPlan{}, err
+	}
+
+	var plan ImportPlan
+	for _, ds := range datastores {
+		log.Printf("scan datastore %q (%s)", ds.Name, ds.UUID)
+
+		p, err := createImportPlan(fsys, ds, vmName, snapshotMode)
+		if err != nil {
+			return ImportPlan{}, fmt.Errorf("datastore %q: %w", ds.Name, err)
+		}
+
+		plan.VirtualServers = append(plan.VirtualServers, p.VirtualServers...)
+	}
+
+	return plan, nil
+}
+
+// hostImportPlan is an import plan of a single source host.
+type hostImportPlan struct {
+	ImportPlan
+	// registered contains VMX file paths of virtual servers which are registered on the host.
+	registered map[string]bool
+}
+
+// mergeImportPlans merges import plans of several hosts. A virtual server on a datastore shared
+// between hosts is added once, from the host it's registered on, or from the first host if it's
+// not registered anywhere. It fails if the virtual server is registered on several hosts.
+func mergeImportPlans(plans ...hostImportPlan) (ImportPlan, error) {
+	var merged ImportPlan
+	// shared contains indexes of virtual servers on shared datastores in the merged plan.
+	shared := map[string]int{}
+	registered := map[string]bool{}
+
+	for _, p := range plans {
+		for _, vs := range p.VirtualServers {
+			if vs.DatastoreUUID == "" {
+				merged.VirtualServers = append(merged.VirtualServers, vs)
+				continue
+			}
+
+			key := vs.DatastoreUUID + "/" + filepath.Base(vs.OriginDir)
+			i, ok := shared[key]
+			if !ok {
+				shared[key] = len(merged.VirtualServers)
+				registered[key] = p.registered[vs.VMXFilePath]
+				merged.VirtualServers = append(merged.VirtualServers, vs)
+				continue
+			}
+
+			found := merged.VirtualServers[i]
+			switch {
+			case p.registered[vs.VMXFilePath] && registered[key]:
+				return ImportPlan{}, fmt.Errorf("virtual server %q is registered on both hosts %s and %s, unregister it on one of them",
+					vs.OriginName, found.SourceHost, vs.SourceHost)
+			case p.registered[vs.VMXFilePath]:
+				log.Printf("virtual server %q is registered on host %s, it's imported from there instead of host %s",
+					vs.OriginName, vs.SourceHost, found.SourceHost)
+				merged.VirtualServers[i] = vs
+				registered[key] = true
+			default:
+				log.Printf("virtual server %q on host %s is already found on host %s, skip it",
+					vs.OriginName, vs.SourceHost, found.SourceHost)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// sourceHostsOf returns distinct source hosts of virtual servers in vmDir. defaultHost is used for
+// virtual servers without source host, like ones from plans created before multi-host support.
+func (i *ImportPlan) sourceHostsOf(vmDir, defaultHost string) ([]string, error) {
+	var hosts []string
+	seen := map[string]bool{}
+
+	for _, vs := range i.VirtualServers {
+		if !vs.MatchesVMDir(vmDir) {
+			continue
+		}
+
+		host := vs.SourceHost
+		if host == "" {
+			host = defaultHost
+		}
+		if host == "" {
+			return nil, fmt.Errorf("virtual server %q has no source host and default source host is not set", vs.OriginName)
+		}
+
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreateHostImportPlan(t *testing.T) {
+	plan, err := createHostImportPlan(testESXiFS(), "", "", SnapshotModeConsolidate)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var names []string
+	for _, vs := range plan.VirtualServers {
+		names = append(names, vs.OriginName)
+	}
+	if !equalStrings(names, []string{"db", "Web Server"}) {
+		t.Fatalf("virtual servers are %v, expected db and Web Server", names)
+	}
+
+	web := plan.VirtualServers[1]
+	if web.Datastore != "datastore1" || web.DatastoreUUID != "5f1a-01" {
+		t.Errorf("datastore is %q with UUID %q", web.Datastore, web.DatastoreUUID)
+	}
+	if web.OriginDir != "/vmfs/volumes/datastore1/web" {
+		t.Errorf("origin directory is %q", web.OriginDir)
+	}
+
+	if plan.VirtualServers[0].SnapshotMode != SnapshotModeConsolidate {
+		t.Errorf("snapshot mode of db is %q", plan.VirtualServers[0].SnapshotMode)
+	}
+}
+
+func TestCreateHostImportPlanOfStoragePath(t *testing.T) {
+	plan, err := createHostImportPlan(testESXiFS(), "/vmfs/volumes/datastore1", "web", SnapshotModeFail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(plan.VirtualServers) != 1 || plan.VirtualServers[0].OriginName != "Web Server" {
+		t.Fatalf("unexpected virtual servers %+v", plan.VirtualServers)
+	}
+	if plan.VirtualServers[0].DatastoreUUID != "5f1a-01" {
+		t.Errorf("datastore UUID is %q", plan.VirtualServers[0].DatastoreUUID)
+	}
+
+	plan, err = createHostImportPlan(testESXiFS(), "/vmfs/volumes/datastore2", "", SnapshotModeFail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(plan.VirtualServers) != 0 {
+		t.Errorf("virtual server with .skip_import is not skipped")
+	}
+}
+
+func TestMergeImportPlans(t *testing.T) {
+	shared := func(host string) VirtualServer {
+		return VirtualServer{
+			OriginName:    "db",
+			OriginDir:     "/vmfs/volumes/shared/db",
+			VMXFilePath:   "/vmfs/volumes/shared/db/db.vmx",
+			DatastoreUUID: "5f1a-03",
+			SourceHost:    host,
+		}
+	}
+	hostPlan := func(host string, registered bool) hostImportPlan {
+		p := hostImportPlan{
+			ImportPlan: ImportPlan{VirtualServers: []VirtualServer{
+				shared(host),
+				{OriginName: "web " + host, OriginDir: "/vmfs/volumes/local/web", DatastoreUUID: "local-" + host, SourceHost: host},
+			}},
+			registered: map[string]bool{},
+		}
+		if registered {
+			p.registered["/vmfs/volumes/shared/db/db.vmx"] = true
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		plans    []hostImportPlan
+		wantHost string
+		wantErr  bool
+	}{
+		{name: "registered on the first host", plans: []hostImportPlan{hostPlan("a", true), hostPlan("b", false)}, wantHost: "a"},
+		{name: "registered on the second host", plans: []hostImportPlan{hostPlan("a", false), hostPlan("b", true)}, wantHost: "b"},
+		{name: "not registered", plans: []hostImportPlan{hostPlan("a", false), hostPlan("b", false)}, wantHost: "a"},
+		{name: "registered on both hosts", plans: []hostImportPlan{hostPlan("a", true), hostPlan("b", true)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := mergeImportPlans(tt.plans...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(plan.VirtualServers) != 3 {
+				t.Fatalf("virtual servers are %+v, expected shared one and both local ones", plan.VirtualServers)
+			}
+			if host := plan.VirtualServers[0].SourceHost; host != tt.wantHost {
+				t.Errorf("shared virtual server is imported from host %q, expected %q", host, tt.wantHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ImportState is a state of a virtual server import. It's stored in the import plan,
+// so disks import can be resumed from the last completed step.
+type ImportState string
+
+const (
+	ImportStatePlanned         ImportState = "planned"
+	ImportStateCreated         ImportState = "created"
+	ImportStateConverting      ImportState = "converting"
+	ImportStateConverted       ImportState = "converted"
+	ImportStateDisksPlaced     ImportState = "disks-placed"
+	ImportStateSettingsUpdated ImportState = "settings-updated"
+	ImportStateDone            ImportState = "done"
+	ImportStateFailed          ImportState = "failed"
+)
+
+// importStatesOrder is an order of import steps, failed state is not a step.
+var importStatesOrder = []ImportState{
+	ImportStatePlanned,
+	ImportStateCreated,
+	ImportStateConverting,
+	ImportStateConverted,
+	ImportStateDisksPlaced,
+	ImportStateSettingsUpdated,
+	ImportStateDone,
+}
+
+func (s ImportState) order() int {
+	for i, state := range importStatesOrder {
+		if state == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsCompleted returns true if the step s is already completed in state current.
+func (s ImportState) IsCompleted(current ImportState) bool {
+	return current.order() >= s.order()
+}
+
+// CurrentImportState returns the last completed import step of the virtual server.
+// For a failed virtual server it returns the step to resume from.
+func (vs VirtualServer) CurrentImportState() ImportState {
+	switch {
+	case vs.ImportState == ImportStateFailed:
+		if vs.ImportResumeState != "" {
+			return vs.ImportResumeState
+		}
+	case vs.ImportState != "":
+		return vs.ImportState
+	}
+
+	if vs.VirtualServerID != 0 {
+		return ImportStateCreated
+	}
+
+	return ImportStatePlanned
+}
+
+// importJournal persists import states of virtual servers to the import plan file.
+// It's safe for concurrent use.
+type importJournal struct {
+	mu                 sync.Mutex
+	importPlanFilePath string
+	plan               *ImportPlan
+}
+
+func newImportJournal(importPlanFilePath string, plan *ImportPlan) *importJournal {
+	return &importJournal{
+		importPlanFilePath: importPlanFilePath,
+		plan:               plan,
+	}
+}
+
+// VirtualServer returns a copy of i-th virtual server.
+func (j *importJournal) VirtualServer(i int) VirtualServer {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.plan.VirtualServers[i]
+}
+
+// SetState sets state of i-th virtual server and saves the plan.
+func (j *importJournal) SetState(i int, state ImportState) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	vs := &j.plan.VirtualServers[i]
+	vs.ImportState = state
+	vs.ImportResumeState = ""
+	vs.ImportError = ""
+
+	log.Printf("virtual server %q import state is %q", vs.OriginName, state)
+
+	return j.save()
+}
+
+// Fail marks i-th virtual server as failed and saves the plan. The last completed step is kept
+// to resume the import from it.
+func (j *importJournal) Fail(i int, importErr error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	vs := &j.plan.VirtualServers[i]
+	resume := vs.CurrentImportState()
+	if resume == ImportStateConverting {
+		// Conversion was interrupted and has to be started from scratch.
+		resume = ImportStateCreated
+	}
+
+	vs.ImportState = ImportStateFailed
+	vs.ImportResumeState = resume
+	vs.ImportError = importErr.Error()
+
+	log.Printf("virtual server %q import failed at %q: %s", vs.OriginName, resume, importErr)
+
+	return j.save()
+}
+
+func (j *importJournal) save() error {
+	if err := saveImportPlan(j.importPlanFilePath, *j.plan); err != nil {
+		return fmt.Errorf("save import journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportStateIsCompleted(t *testing.T) {
+	tests := []struct {
+		step    ImportState
+		current ImportState
+		want    bool
+	}{
+		{ImportStateCreated, ImportStatePlanned, false},
+		{ImportStateCreated, ImportStateCreated, true},
+		{ImportStateConverted, ImportStateDisksPlaced, true},
+		{ImportStateDone, ImportStateSettingsUpdated, false},
+		{ImportStateCreated, ImportStateFailed, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.step.IsCompleted(tt.current); got != tt.want {
+			t.Errorf("%q.IsCompleted(%q) = %t, expected %t", tt.step, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestCurrentImportState(t *testing.T) {
+	tests := []struct {
+		name string
+		vs   VirtualServer
+		want ImportState
+	}{
+		{name: "new", want: ImportStatePlanned},
+		{name: "created by old version", vs: VirtualServer{VirtualServerID: 10}, want: ImportStateCreated},
+		{name: "converted", vs: VirtualServer{VirtualServerID: 10, ImportState: ImportStateConverted}, want: ImportStateConverted},
+		{
+			name: "failed",
+			vs:   VirtualServer{VirtualServerID: 10, ImportState: ImportStateFailed, ImportResumeState: ImportStateDisksPlaced},
+			want: ImportStateDisksPlaced,
+		},
+		{name: "failed without resume state", vs: VirtualServer{ImportState: ImportStateFailed}, want: ImportStatePlanned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.vs.CurrentImportState(); got != tt.want {
+				t.Errorf("current import state is %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	plan := ImportPlan{VirtualServers: []VirtualServer{{OriginName: "vm", VirtualServerID: 10}}}
+	j := newImportJournal(path, &plan)
+
+	if err := j.SetState(0, ImportStateConverting); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := j.Fail(0, errors.New("virt-v2v failed")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	saved, err := loadImportPlan(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	vs := saved.VirtualServers[0]
+	if vs.ImportState != ImportStateFailed || vs.ImportError != "virt-v2v failed" {
+		t.Errorf("unexpected import state %q and error %q", vs.ImportState, vs.ImportError)
+	}
+	// Interrupted conversion is started from scratch.
+	if vs.CurrentImportState() != ImportStateCreated {
+		t.Errorf("resume state is %q, expected %q", vs.CurrentImportState(), ImportStateCreated)
+	}
+
+	if err := j.SetState(0, ImportStateConverted); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := j.VirtualServer(0); got.ImportResumeState != "" || got.ImportError != "" {
+		t.Errorf("failure is not cleared: resume state %q, error %q", got.ImportResumeState, got.ImportError)
+	}
+}
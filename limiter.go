@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+)
+
+// keyedLimiter limits the number of concurrent operations per key, like a source
+// host or a destination datastore.
+type keyedLimiter struct {
+	mu    sync.Mutex
+	limit int
+	sems  map[string]chan struct{}
+}
+
+// newKeyedLimiter creates a limiter, zero or negative limit means no limit.
+func newKeyedLimiter(limit int) *keyedLimiter {
+	return &keyedLimiter{
+		limit: limit,
+		sems:  map[string]chan struct{}{},
+	}
+}
+
+// Acquire blocks until an operation for the key is allowed and returns a function to release it.
+func (l *keyedLimiter) Acquire(key string) func() {
+	if l.limit <= 0 {
+		return func() {}
+	}
+
+	l.mu.Lock()
+	sem, ok := l.sems[key]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.sems[key] = sem
+	}
+	l.mu.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	l := newKeyedLimiter(2)
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release := l.Acquire("host1")
+			defer release()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > 2 {
+		t.Errorf("%d operations were running concurrently, limit is 2", maxRunning)
+	}
+}
+
+func TestKeyedLimiterKeysAreIndependent(t *testing.T) {
+	l := newKeyedLimiter(1)
+
+	release := l.Acquire("host1")
+	defer release()
+
+	done := make(chan struct{})
+	go func() {
+		l.Acquire("host2")()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("operation for another key is blocked")
+	}
+}
+
+func TestKeyedLimiterWithoutLimit(t *testing.T) {
+	l := newKeyedLimiter(0)
+
+	for i := 0; i < 10; i++ {
+		// Releases are not called, nothing must block.
+		l.Acquire("host1")
+	}
+}
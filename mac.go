@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	"github.com/solusio/import-vmware/command"
+	"github.com/solusio/solus-go-sdk"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// macAddresses returns MAC addresses of the source network interfaces in VMX index order.
+// Plans created before network interfaces recording have the primary MAC address only.
+func (vs VirtualServer) macAddresses() []string {
+	if len(vs.NetworkInterfaces) == 0 {
+		if vs.MacAddress != nil && *vs.MacAddress != "" {
+			return []string{*vs.MacAddress}
+		}
+		return nil
+	}
+
+	macs := make([]string, 0, len(vs.NetworkInterfaces))
+	for _, nic := range vs.NetworkInterfaces {
+		macs = append(macs, nic.MacAddress)
+	}
+	return macs
+}
+
+// reportMACAddresses warns about MAC addresses of the source network interfaces which SolusVM 2 doesn't set,
+// only the primary one is set on creation.
+func reportMACAddresses(vs VirtualServer) {
+	macs := vs.macAddresses()
+	if len(macs) < 2 {
+		return
+	}
+
+	log.Printf("WARNING: virtual server %q MAC addresses %s of additional network interfaces are not preserved, "+
+		"option -apply-mac-addresses overrides them in the libvirt domain until SolusVM 2 regenerates it", vs.OriginName, strings.Join(macs[1:], ", "))
+}
+
+// overrideMACAddresses sets MAC addresses to network interfaces of the domain in the same order.
+// If the domain has fewer network interfaces than MAC addresses, the ones which fit are set and
+// the rest are returned as skipped.
+func overrideMACAddresses(domain *libvirtxml.Domain, macs []string) (changed bool, skipped []string) {
+	var interfaces []libvirtxml.DomainInterface
+	if domain.Devices != nil {
+		interfaces = domain.Devices.Interfaces
+	}
+
+	if len(macs) > len(interfaces) {
+		skipped = macs[len(interfaces):]
+		macs = macs[:len(interfaces)]
+	}
+
+	for i, mac := range macs {
+		if mac == "" {
+			continue
+		}
+
+		if interfaces[i].MAC != nil && strings.EqualFold(interfaces[i].MAC.Address, mac) {
+			continue
+		}
+
+		interfaces[i].MAC = &libvirtxml.DomainInterfaceMAC{Address: strings.ToLower(mac)}
+		changed = true
+	}
+
+	return changed, skipped
+}
+
+// waitForServerTasks waits until all SolusVM 2 tasks of the virtual server are finished, so the libvirt
+// domain is not changed by SolusVM 2 at the same time.
+func waitForServerTasks(settings ImportSettings, id int) error {
+	client, err := newSolusClient(settings)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(taskTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+		resp, err := client.Tasks.List(ctx, (&solus.FilterTasks{}).ByComputeResourceVMID(id))
+		cancel()
+		if err != nil {
+			return fmt.Errorf("list tasks of virtual server %d: %w", id, err)
+		}
+
+		running := 0
+		for _, t := range resp.Data {
+			if !t.IsFinished() {
+				running++
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d tasks of virtual server %d are not finished within %s", running, id, taskTimeout)
+		}
+		time.Sleep(taskPollInterval)
+	}
+}
+
+// applyMACAddresses overrides MAC addresses of network interfaces of the created virtual server in its
+// libvirt domain with MAC addresses of the source network interfaces in the same order, since guests tie
+// static IP configuration and licenses to them. It's a partial workaround: SolusVM 2 keeps its own MAC
+// addresses, so the override is not persistent and is reverted when SolusVM 2 regenerates the domain.
+// The domain must be stopped.
+func applyMACAddresses(settings ImportSettings, vs VirtualServer) error {
+	macs := vs.macAddresses()
+	if len(macs) == 0 {
+		return nil
+	}
+
+	if err := waitForServerTasks(settings, vs.VirtualServerID); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	err := command.DefaultCommander.Build("virsh", "dumpxml", "--inactive", vs.VirtualServerUUID).
+		WithStdOut(&out).
+		WithNoInfoLog().
+		Exec()
+	if err != nil {
+		return fmt.Errorf("dump domain %s: %w", vs.VirtualServerUUID, err)
+	}
+
+	var domain libvirtxml.Domain
+	if err := domain.Unmarshal(out.String()); err != nil {
+		return fmt.Errorf("decode domain %s: %w", vs.VirtualServerUUID, err)
+	}
+
+	changed, skipped := overrideMACAddresses(&domain, macs)
+	if len(skipped) > 0 {
+		log.Printf("WARNING: virtual server %q domain has fewer network interfaces than the source virtual machine, "+
+			"MAC addresses %s are not applied", vs.OriginName, strings.Join(skipped, ", "))
+		macs = macs[:len(macs)-len(skipped)]
+	}
+	if !changed {
+		return nil
+	}
+
+	data, err := domain.Marshal()
+	if err != nil {
+		return fmt.Errorf("encode domain %s: %w", vs.VirtualServerUUID, err)
+	}
+
+	f, err := os.CreateTemp("", "import-vmware-domain-*.xml")
+	if err != nil {
+		return fmt.Errorf("create domain file: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.WriteString(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write domain file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close domain file: %w", err)
+	}
+
+	if err := command.DefaultCommander.Build("virsh", "define", f.Name()).Exec(); err != nil {
+		return fmt.Errorf("define domain %s: %w", vs.VirtualServerUUID, err)
+	}
+
+	log.Printf("WARNING: virtual server %q MAC addresses are overridden to %s in the libvirt domain, "+
+		"the change does not persist: SolusVM 2 reverts them without notice when it regenerates the domain", vs.OriginName, strings.Join(macs, ", "))
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	"testing"
+)
+
+func TestOverrideMACAddresses(t *testing.T) {
+	newDomain := func(macs ...string) *libvirtxml.Domain {
+		domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+		for _, mac := range macs {
+			domain.Devices.Interfaces = append(domain.Devices.Interfaces, libvirtxml.DomainInterface{
+				MAC: &libvirtxml.DomainInterfaceMAC{Address: mac},
+			})
+		}
+		return domain
+	}
+
+	t.Run("override", func(t *testing.T) {
+		domain := newDomain("52:54:00:00:00:01", "52:54:00:00:00:02")
+
+		changed, skipped := overrideMACAddresses(domain, []string{"00:50:56:AA:00:01", "00:50:56:aa:00:02"})
+		if len(skipped) != 0 {
+			t.Fatalf("unexpected skipped MAC addresses %v", skipped)
+		}
+		if !changed {
+			t.Fatal("expected domain to be changed")
+		}
+
+		for i, want := range []string{"00:50:56:aa:00:01", "00:50:56:aa:00:02"} {
+			if got := domain.Devices.Interfaces[i].MAC.Address; got != want {
+				t.Errorf("interface %d MAC address is %q, want %q", i, got, want)
+			}
+		}
+	})
+
+	t.Run("already applied", func(t *testing.T) {
+		domain := newDomain("00:50:56:aa:00:01", "52:54:00:00:00:02")
+
+		changed, _ := overrideMACAddresses(domain, []string{"00:50:56:AA:00:01", ""})
+		if changed {
+			t.Fatal("expected domain not to be changed")
+		}
+	})
+
+	t.Run("fewer interfaces", func(t *testing.T) {
+		domain := newDomain("52:54:00:00:00:01")
+
+		changed, skipped := overrideMACAddresses(domain, []string{"00:50:56:aa:00:01", "00:50:56:aa:00:02"})
+		if !changed {
+			t.Fatal("expected domain to be changed")
+		}
+		if got := domain.Devices.Interfaces[0].MAC.Address; got != "00:50:56:aa:00:01" {
+			t.Errorf("interface 0 MAC address is %q, want %q", got, "00:50:56:aa:00:01")
+		}
+		if len(skipped) != 1 || skipped[0] != "00:50:56:aa:00:02" {
+			t.Errorf("skipped MAC addresses are %v, want [00:50:56:aa:00:02]", skipped)
+		}
+	})
+
+	t.Run("no devices", func(t *testing.T) {
+		changed, skipped := overrideMACAddresses(&libvirtxml.Domain{}, []string{"00:50:56:aa:00:01"})
+		if changed || len(skipped) != 1 {
+			t.Errorf("changed is %t and skipped MAC addresses are %v, want nothing changed and one skipped", changed, skipped)
+		}
+	})
+}
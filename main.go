@@ -2,14 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/solusio/import-vmware/common"
-	"github.com/solusio/import-vmware/ssh"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
@@ -23,29 +24,70 @@ const (
 	settingsFilePathFlagName                 = "settings-file-path"
 	recreateVirtualServersFlagName           = "recreate-virtual-servers"
 	importDisksFlagName                      = "import-disks"
+	snapshotModeFlagName                     = "snapshot-mode"
+	parallelFlagName                         = "parallel"
 )
 
 func main() {
 	// Step 1
-	createImportPlanFlag := flag.Bool(createImportPlanFlagName, false, "Create import plan for virtual servers in storage-path.")
+	createImportPlanFlag := flag.Bool(createImportPlanFlagName, false, "Create import plan for virtual servers in storage-path of source-ip or all source_hosts from settings file.")
 	sourceIPFlag := flag.String(sourceIPFlagName, "", "Source IP or hostname where virtual servers will be imported from.")
+	sshPortFlag := flag.Int("ssh-port", 22, "Source host SSH port.")
+	sshUserFlag := flag.String("ssh-user", "root", "Source host SSH user.")
+	sshJumpFlag := flag.String("ssh-jump", "", "Optional. Jump host to reach the source host through in `[user@]host[:port]` format like OpenSSH ProxyJump, ssh-user is used by default.")
 	privateKeyFlag := flag.String(privateKeyFlagName, "/root/.ssh/id_rsa", "Private key file path.")
-	storagePathFlag := flag.String(storagePathFlagName, "", "Storage path.")
+	knownHostsFlag := flag.String("known-hosts", "", "Optional. Known hosts file path to verify source host key, ~/.ssh/known_hosts is used by default.")
+	trustOnFirstUseFlag := flag.Bool("trust-on-first-use", false, "Optional. Add a key of unknown source host to known hosts file instead of failing.")
+	sshAgentFlag := flag.Bool("ssh-agent", true, "Use SSH agent available by SSH_AUTH_SOCK for source host authentication.")
+	askSSHPasswordFlag := flag.Bool("ask-ssh-password", false, "Optional. Prompt for source host SSH password if it is not set with "+sourceSSHPasswordEnv+" environment variable.")
+	storagePathFlag := flag.String(storagePathFlagName, "", "Storage path. All datastores in "+esxiVolumesPath+" are scanned when empty.")
 	vmDirFlag := flag.String(vmDirFlagName, "", "Optional. When provided, operation performed for a virtual server stored in that directory only.")
+	snapshotModeFlag := flag.String(snapshotModeFlagName, SnapshotModeFail, "What to do with virtual servers with snapshots: "+
+		"\"fail\" - stop plan creation, "+
+		"\"current\" - import current state of disks from a temporary clone, "+
+		"\"consolidate\" - remove all snapshots on the source host before disks import.")
 
 	// Step 1
 	createSettingsFileFlag := flag.Bool("create-settings-file", false, "Create settings file example.")
 	settingsFilePathFlag := flag.String(settingsFilePathFlagName, "settings.json", "Settings file path.")
+	apiURLFlag := flag.String("api-url", "", "Optional. SolusVM 2 API URL to list available OS images when creating settings file.")
+	askAPITokenFlag := flag.Bool("ask-api-token", false, "Optional. Prompt for SolusVM 2 API token if it is not set with "+solusAPITokenEnv+" environment variable.")
 
 	// Step 3
 	createVirtualServersFlag := flag.Bool(createVirtualServersByImportPlanFlagName, false, "Create virtual servers in SolusVM 2 by import plan.")
 	recreateVirtualServersFlag := flag.Bool(recreateVirtualServersFlagName, false, "Recreate virtual servers in SolusVM 2 by import plan.")
 	importPlanFilePathFlag := flag.String(importPlanFilePathFlagName, "import_plan.json", "Import plan file path.")
+	rollbackFlag := flag.Bool("rollback", false, "Delete virtual servers created in SolusVM 2 by import plan and clear their IDs in the plan.")
+	validateOnlineFlag := flag.Bool("validate-online", false, "Optional. Check import plan references with SolusVM 2 API before creating virtual servers.")
+	dryRunFlag := flag.Bool("dry-run", false, "Optional. Validate import plan, resolve all referenced IDs with SolusVM 2 API and print create requests without creating virtual servers.")
 
 	// Step 4
 	importDisksFlag := flag.Bool(importDisksFlagName, false, "Copy and convert virtual servers disks by import plan from remote source storage path to local destination path.")
+	runningVMFlag := flag.String("running-vm", RunningVMActionRefuse, "What to do with a running virtual server before disks import: "+
+		"\"refuse\" - fail its import, \"skip\" - skip it, \"shutdown\" - shut it down gracefully.")
+	shutdownTimeoutFlag := flag.Duration("shutdown-timeout", 5*time.Minute, "Time to wait for a graceful shutdown of a running virtual server.")
+	uncleanShutdownFlag := flag.String("unclean-shutdown", UncleanShutdownActionRefuse, "What to do with a Windows virtual server which was not shut down cleanly: "+
+		"\"refuse\" - fail its import, \"warn\" - import it anyway, \"fix\" - boot it and shut it down gracefully.")
+	applyMACAddressesFlag := flag.Bool("apply-mac-addresses", false, "Optional. Temporarily override MAC addresses of all network interfaces in the libvirt domain "+
+		"after disks import. The change does not persist: SolusVM 2 reverts it without notice whenever it regenerates the domain, "+
+		"and guests which tie licenses or static IP configuration to MAC addresses break again.")
+	forcePowerOffFlag := flag.Bool("force-power-off", false, "Optional. Power off a running virtual server which is not shut down gracefully in shutdown-timeout.")
+	parallelFlag := flag.Int(parallelFlagName, 1, "Number of virtual servers which disks are imported concurrently.")
+	parallelPerSourceHostFlag := flag.Int("parallel-per-source-host", 0, "Optional. Maximum number of concurrent disks imports from the same source host.")
+	parallelPerDatastoreFlag := flag.Int("parallel-per-datastore", 0, "Optional. Maximum number of concurrent disks imports to the same destination datastore.")
 	flag.Parse()
 
+	sourceSSH := &sourceSSHOptions{
+		Port:            *sshPortFlag,
+		User:            *sshUserFlag,
+		JumpHost:        *sshJumpFlag,
+		PrivateKeyPath:  *privateKeyFlag,
+		KnownHostsPath:  *knownHostsFlag,
+		TrustOnFirstUse: *trustOnFirstUseFlag,
+		UseAgent:        *sshAgentFlag,
+		AskPassword:     *askSSHPasswordFlag,
+	}
+
 	if *createSettingsFileFlag {
 		if common.IsExists(*settingsFilePathFlag) {
 			log.Fatalf("settings file already exists at %s", *settingsFilePathFlag)
@@ -80,6 +122,37 @@ func main() {
 			},
 		}
 
+		// With API access guest OSes are mapped by OS image names, available images are listed for them.
+		if *apiURLFlag != "" {
+			token, err := solusAPIToken(*askAPITokenFlag)
+			if err != nil {
+				log.Fatalf("failed to get SolusVM 2 API token: %v", err)
+			}
+
+			settings.APIURL = *apiURLFlag
+			settings.APIToken = token
+
+			client, err := newSolusClient(settings)
+			if err != nil {
+				log.Fatalf("failed to create SolusVM 2 client: %v", err)
+			}
+
+			refs, err := listOSImageVersions(client)
+			if err != nil {
+				log.Fatalf("failed to list OS images: %v", err)
+			}
+
+			guestOSes := make([]string, 0, len(settings.Defaults.GuestOSToOSImageVersionID))
+			settings.Defaults.GuestOSToOSImage = map[string]string{}
+			for guestOS := range settings.Defaults.GuestOSToOSImageVersionID {
+				guestOSes = append(guestOSes, guestOS)
+				settings.Defaults.GuestOSToOSImage[guestOS] = ""
+			}
+			settings.Defaults.GuestOSToOSImageVersionID = map[string]int{}
+
+			printOSImageCandidates(guestOSes, refs)
+		}
+
 		if err := saveSettings(*settingsFilePathFlag, settings); err != nil {
 			log.Fatalf("failed to create settings file: %v", err)
 		}
@@ -88,12 +161,25 @@ func main() {
 	}
 
 	if *createImportPlanFlag {
-		if *storagePathFlag == "" {
-			log.Fatal("storage-path is empty")
+		if !isValidSnapshotMode(*snapshotModeFlag) {
+			log.Fatalf("invalid snapshot-mode %q", *snapshotModeFlag)
+		}
+
+		var settings ImportSettings
+		if common.IsExists(*settingsFilePathFlag) {
+			var err error
+			if settings, err = loadSettings(*settingsFilePathFlag); err != nil {
+				log.Fatalf("failed to load settings: %v", err)
+			}
+		}
+
+		sourceHosts := settings.SourceHosts
+		if *sourceIPFlag != "" {
+			sourceHosts = []string{*sourceIPFlag}
 		}
 
-		if *sourceIPFlag == "" {
-			importPlan, err := createImportPlan(*storagePathFlag, *vmDirFlag)
+		if len(sourceHosts) == 0 {
+			importPlan, err := createHostImportPlan(localFS{}, *storagePathFlag, *vmDirFlag, *snapshotModeFlag)
 			if err != nil {
 				log.Fatalf("failed to create import plan: %v", err)
 			}
@@ -105,26 +191,66 @@ func main() {
 			return
 		}
 
-		node, err := ssh.NewNodeConnection(*sourceIPFlag, 22, "root", *privateKeyFlag)
-		if err != nil {
-			log.Fatalf("failed to create node connection: %v", err)
+		if err := settings.checkHostKeyFingerprints(sourceHosts); err != nil {
+			log.Fatal(err)
 		}
 
-		if err := node.UploadAgent(); err != nil {
-			log.Fatalf("failed to upload agent: %v", err)
+		plans := make([]hostImportPlan, 0, len(sourceHosts))
+		for _, host := range sourceHosts {
+			node, err := sourceSSH.Connect(host, settings)
+			if err != nil {
+				log.Fatalf("failed to create node connection to %s: %v", host, err)
+			}
+
+			// VMX files and VMDK descriptors are read over SFTP and parsed locally,
+			// nothing is executed on the source host.
+			hostPlan, err := createHostImportPlan(sftpFS{conn: node.Connection()}, *storagePathFlag, *vmDirFlag, *snapshotModeFlag)
+			if err != nil {
+				log.Fatalf("failed to create import plan of host %s: %v", host, err)
+			}
+
+			// Virtual machines registered on the host are looked up once for both power states and guest IPs.
+			vms, err := newESXiVMIndex(node)
+			if err != nil {
+				log.Fatalf("failed to get virtual machines of host %s: %v", host, err)
+			}
+
+			// A virtual server on a shared datastore is imported from the host it's registered on.
+			registered, err := vms.registered(hostPlan)
+			if err != nil {
+				log.Fatalf("failed to check registration of virtual servers on host %s: %v", host, err)
+			}
+
+			if err := recordPowerStates(node, vms, &hostPlan); err != nil {
+				log.Fatalf("failed to get power states of virtual servers of host %s: %v", host, err)
+			}
+
+			if err := recordGuestIPs(node, vms, &hostPlan); err != nil {
+				log.Fatalf("failed to get guest IP addresses of virtual servers of host %s: %v", host, err)
+			}
+			common.CloseWrapper(node)
+
+			for i := range hostPlan.VirtualServers {
+				hostPlan.VirtualServers[i].SourceHost = host
+			}
+			plans = append(plans, hostImportPlan{ImportPlan: hostPlan, registered: registered})
 		}
 
-		importFilePath := filepath.Join(*storagePathFlag, filepath.Base(*importPlanFilePathFlag))
-		args := fmt.Sprintf("-%s -%s %s -%s %q", createImportPlanFlagName, importPlanFilePathFlagName, importFilePath, storagePathFlagName, *storagePathFlag)
-		if *vmDirFlag != "" {
-			args += fmt.Sprintf(" -%s %q", vmDirFlagName, *vmDirFlag)
+		importPlan, err := mergeImportPlans(plans...)
+		if err != nil {
+			log.Fatalf("failed to merge import plans of hosts: %v", err)
 		}
-		if out, err := node.ExecAgent(args); err != nil {
-			log.Fatalf("failed to exec agent %s: %v", string(out), err)
+
+		if settings.APIURL != "" && settings.APIURL != apiURLExample && settings.APIToken != "" {
+			if blocks, err := listIPBlocks(settings); err != nil {
+				log.Printf("guest IP addresses are not checked against SolusVM 2 IP blocks, primary IP is not prefilled: %v", err)
+			} else {
+				markGuestIPBlocks(&importPlan, blocks)
+			}
 		}
 
-		if err := node.DownloadFile(importFilePath, *importPlanFilePathFlag); err != nil {
-			log.Fatalf("failed to download import plan file: %v", err)
+		if err := saveImportPlan(*importPlanFilePathFlag, importPlan); err != nil {
+			log.Fatalf("failed to create import plan file: %v", err)
 		}
 
 		log.Printf("created import plan file, you can use like %s -%s -%s %s",
@@ -143,58 +269,137 @@ func main() {
 			log.Fatalf("failed to load settings: %v", err)
 		}
 
-		if err := createVirtualServers(settings, *importPlanFilePathFlag, *recreateVirtualServersFlag); err != nil {
+		opts := createVirtualServersOptions{
+			Recreate:       *recreateVirtualServersFlag,
+			DryRun:         *dryRunFlag,
+			ValidateOnline: *validateOnlineFlag,
+		}
+
+		if err := createVirtualServers(settings, *importPlanFilePathFlag, opts); err != nil {
 			log.Fatalf("failed to create virtual servers: %v", err)
 		}
 
+		if *dryRunFlag {
+			return
+		}
+
 		// ./vmware-importer -import-disks -source-ip 37.27.122.43 -private-key ~/.ssh/id_rsa -import-plan-file-path win2k22.json
 		log.Printf("Virtual servers are created, you can import disks like %s -%s -%s %s",
 			os.Args[0], importDisksFlagName, importPlanFilePathFlagName, *importPlanFilePathFlag)
 		return
 	}
 
-	if *importDisksFlag {
+	if *rollbackFlag {
 		settings, err := loadSettings(*settingsFilePathFlag)
 		if err != nil {
 			log.Fatalf("failed to load settings: %v", err)
 		}
 
-		if *sourceIPFlag == "" && settings.SourceIP == "" {
-			log.Fatalf("Source IP not provided with flag -%s or settings file", sourceIPFlagName)
+		if err := rollbackVirtualServers(settings, *importPlanFilePathFlag, *vmDirFlag); err != nil {
+			log.Fatalf("failed to rollback virtual servers: %v", err)
 		}
 
-		sourceIP := settings.SourceIP
-		if *sourceIPFlag != "" {
-			sourceIP = *sourceIPFlag
+		return
+	}
+
+	if *importDisksFlag {
+		if *parallelFlag < 1 {
+			log.Fatalf("-%s must be greater than zero", parallelFlagName)
 		}
 
-		plan, err := loadImportPlan(*importPlanFilePathFlag)
-		if err != nil {
-			log.Fatalf("failed to load import plan: %v", err)
+		if !isValidRunningVMAction(*runningVMFlag) {
+			log.Fatalf("invalid running-vm %q", *runningVMFlag)
 		}
 
-		plan.Settings = settings
+		if !isValidUncleanShutdownAction(*uncleanShutdownFlag) {
+			log.Fatalf("invalid unclean-shutdown %q", *uncleanShutdownFlag)
+		}
 
-		if *vmDirFlag != "" {
-			for _, vs := range plan.VirtualServers {
-				if vs.OriginDir == *vmDirFlag {
-					plan.VirtualServers = []VirtualServer{vs}
-					break
-				}
-			}
+		opts := importDisksOptions{
+			SourceSSHUser: *sshUserFlag,
+			RunningVM: runningVMOptions{
+				Action:          *runningVMFlag,
+				ShutdownTimeout: *shutdownTimeoutFlag,
+				ForcePowerOff:   *forcePowerOffFlag,
+			},
+			UncleanShutdown:       *uncleanShutdownFlag,
+			ApplyMACAddresses:     *applyMACAddressesFlag,
+			ImportPlanFilePath:    *importPlanFilePathFlag,
+			VMDir:                 *vmDirFlag,
+			Parallel:              *parallelFlag,
+			ParallelPerSourceHost: *parallelPerSourceHostFlag,
+			ParallelPerDatastore:  *parallelPerDatastoreFlag,
 		}
 
-		if err := importDisks(sourceIP, plan); err != nil {
-			log.Fatalf("failed to import disks: %v", err)
+		if err := importDisksByPlan(sourceSSH, *settingsFilePathFlag, *sourceIPFlag, opts); err != nil {
+			log.Fatal(err)
 		}
 
 		return
 	}
 }
 
-// CreateImportPlan creates an import plan for virtual machines in a storage path like /vmfs/volumes/testdatastore
-func createImportPlan(storagePath, vmName string) (ImportPlan, error) {
-	storageDir, err := os.ReadDir(storagePath)
+// importDisksByPlan connects to source hosts of the plan and imports disks with opts.
+// Source hosts are closed before it returns, so tunnels remove their known hosts entries
+// whether the import succeeds or not.
+func importDisksByPlan(sourceSSH *sourceSSHOptions, settingsFilePath, sourceIP string, opts importDisksOptions) error {
+	settings, err := loadSettings(settingsFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	opts.DefaultSourceHost = settings.SourceIP
+	if sourceIP != "" {
+		opts.DefaultSourceHost = sourceIP
+	}
+
+	plan, err := loadImportPlan(opts.ImportPlanFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load import plan: %w", err)
+	}
+
+	plan.Settings = settings
+
+	hosts, err := plan.sourceHostsOf(opts.VMDir, opts.DefaultSourceHost)
+	if err != nil {
+		return fmt.Errorf("source IP is not provided with flag -%s or settings file: %w", sourceIPFlagName, err)
+	}
+
+	if err := settings.checkHostKeyFingerprints(hosts); err != nil {
+		return err
+	}
+
+	opts.SSHPassword, err = sourceSSH.Password()
+	if err != nil {
+		return fmt.Errorf("failed to get source host SSH password: %w", err)
+	}
+
+	opts.SourceHosts = make(map[string]*sourceHost, len(hosts))
+	defer func() {
+		for _, h := range opts.SourceHosts {
+			common.CloseWrapper(h)
+		}
+	}()
+
+	for _, host := range hosts {
+		h, err := sourceSSH.ConnectSourceHost(host, settings)
+		if err != nil {
+			return fmt.Errorf("failed to prepare source host: %w", err)
+		}
+		opts.SourceHosts[host] = h
+	}
+
+	if err := importDisks(opts, plan); err != nil {
+		return fmt.Errorf("failed to import disks: %w", err)
+	}
+
+	return nil
+}
+
+// CreateImportPlan creates an import plan for virtual machines in a datastore like /vmfs/volumes/testdatastore
+func createImportPlan(fsys FS, ds datastore, vmName, snapshotMode string) (ImportPlan, error) {
+	storagePath := ds.Path
+	storageDir, err := fsys.ReadDir(storagePath)
 	if err != nil {
 		return ImportPlan{}, err
 	}
@@ -215,17 +420,33 @@ func createImportPlan(storagePath, vmName string) (ImportPlan, error) {
 
 		vsPath := filepath.Join(storagePath, item.Name())
 
-		if common.IsExists(filepath.Join(vsPath, ".skip_import")) {
+		skip, err := isExists(fsys, filepath.Join(vsPath, ".skip_import"))
+		if err != nil {
+			return ImportPlan{}, err
+		}
+		if skip {
 			continue
 		}
 
-		vs, err := ParseVMWareVirtualServerPath(vsPath)
+		vs, err := ParseVMWareVirtualServerPath(fsys, vsPath, snapshotMode)
+		if errors.Is(err, errNoVMXFile) {
+			log.Printf("skip directory %s, it has no vmx file", vsPath)
+			continue
+		}
 		if err != nil {
 			return ImportPlan{}, fmt.Errorf("failed to parse virtual server path %s: %v", vsPath, err)
 		}
 
+		vs.Datastore = ds.Name
+		vs.DatastoreUUID = ds.UUID
+
 		vs.CustomPlan = fillSolusPlanDefaults(vs.CustomPlan)
 
+		if len(vs.ShutdownIssues) > 0 {
+			log.Printf("virtual server %q was not shut down cleanly, its conversion may fail: %s",
+				vs.OriginName, strings.Join(vs.ShutdownIssues, "; "))
+		}
+
 		plan.VirtualServers = append(plan.VirtualServers, vs)
 	}
 
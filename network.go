@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	vmx "github.com/solusio/import-vmware/govmx"
+	"github.com/solusio/solus-go-sdk"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// NetworkInterface is a network adapter of the virtual server taken from VMX file.
+type NetworkInterface struct {
+	// Device is a VMX device ID like ethernet0.
+	Device string `json:"device"`
+	// NetworkName is ESXi portgroup the adapter is connected to.
+	NetworkName string `json:"network_name,omitempty"`
+	// VirtualDev is an adapter model like vmxnet3 or e1000e.
+	VirtualDev string `json:"virtual_dev,omitempty"`
+	MacAddress string `json:"mac_address,omitempty"`
+}
+
+// NetworkMapping is SolusVM 2 network of ESXi portgroup.
+type NetworkMapping struct {
+	IPBlockID int `json:"ip_block_id"`
+	// VLAN is a VLAN tag of the portgroup. It's only reported, VLAN is configured in SolusVM 2 for the IP block.
+	VLAN int `json:"vlan,omitempty"`
+}
+
+// ethernetDeviceRegexp matches a network adapter device ID like ethernet0.
+var ethernetDeviceRegexp = regexp.MustCompile(`^ethernet(\d+)$`)
+
+// ethernetIndex returns an index of a network adapter device ID, -1 if ID is unknown.
+func ethernetIndex(device string) int {
+	m := ethernetDeviceRegexp.FindStringSubmatch(device)
+	if m == nil {
+		return -1
+	}
+
+	index, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+// networkInterfacesFromVMX returns present network adapters in VMX index order, the first one is primary.
+func networkInterfacesFromVMX(ethernet []vmx.Ethernet) []NetworkInterface {
+	var interfaces []NetworkInterface
+
+	for _, e := range ethernet {
+		if !e.Present {
+			continue
+		}
+
+		nic := NetworkInterface{
+			Device:      e.VMXID,
+			NetworkName: e.NetworkName,
+			VirtualDev:  e.VirtualDev,
+		}
+
+		switch e.AddressType {
+		case vmx.MAC_TYPE_STATIC:
+			nic.MacAddress = e.Address
+		default:
+			nic.MacAddress = e.GeneratedAddress
+		}
+
+		interfaces = append(interfaces, nic)
+	}
+
+	// VMX file is decoded in random order.
+	sort.SliceStable(interfaces, func(i, j int) bool {
+		return ethernetIndex(interfaces[i].Device) < ethernetIndex(interfaces[j].Device)
+	})
+
+	return interfaces
+}
+
+// networkMappings returns SolusVM 2 networks of the virtual server interfaces in the same order.
+// Nothing is returned if portgroup mapping is not configured.
+func (vs VirtualServer) networkMappings(settings ImportSettings) ([]NetworkMapping, error) {
+	portgroups := settings.Defaults.PortgroupToNetwork
+	if len(portgroups) == 0 {
+		return nil, nil
+	}
+
+	mappings := make([]NetworkMapping, 0, len(vs.NetworkInterfaces))
+	for _, nic := range vs.NetworkInterfaces {
+		m, ok := portgroups[nic.NetworkName]
+		if !ok || m.IPBlockID == 0 {
+			return nil, fmt.Errorf("virtual server's %s network interface %s portgroup %q is not mapped to IP block in settings portgroup_to_network",
+				vs.Hostname, nic.Device, nic.NetworkName)
+		}
+		mappings = append(mappings, m)
+	}
+
+	return mappings, nil
+}
+
+// additionalIPCount returns a number of additional IPv4 addresses of the virtual server. It's taken from
+// the plan if it's set, otherwise every interface after the primary one gets an address if portgroup mapping
+// is configured.
+func (vs VirtualServer) additionalIPCount(settings ImportSettings) *int {
+	if vs.AdditionalIPv4 != nil {
+		return vs.AdditionalIPv4
+	}
+
+	if len(settings.Defaults.PortgroupToNetwork) == 0 || len(vs.NetworkInterfaces) < 2 {
+		return nil
+	}
+
+	count := len(vs.NetworkInterfaces) - 1
+	return &count
+}
+
+// ipBlockInfo is a part of SolusVM 2 IP block, solus.IPBlock can't decode compute resources.
+type ipBlockInfo struct {
+	Name             string          `json:"name"`
+	Type             solus.IPVersion `json:"type"`
+	ListType         solus.ListType  `json:"list_type"`
+	From             string          `json:"from"`
+	To               string          `json:"to"`
+	Gateway          string          `json:"gateway"`
+	ComputeResources []ipBlockMember `json:"compute_resources"`
+	// IPs are addresses of the block which are already allocated.
+	IPs []ipBlockAddress `json:"ips"`
+}
+
+type ipBlockMember struct {
+	ID int `json:"id"`
+}
+
+type ipBlockAddress struct {
+	IP string `json:"ip"`
+}
+
+func (b ipBlockInfo) hasComputeResource(id int) bool {
+	for _, cr := range b.ComputeResources {
+		if cr.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if IP belongs to the IPv4 range of the block. IP of a block of other type
+// is not checked.
+func (b ipBlockInfo) contains(ip string) bool {
+	if b.Type != solus.IPv4 || b.ListType != solus.IpBlockListTypeRange {
+		return true
+	}
+
+	in, ok := inIPv4Range(b.From, b.To, ip)
+	return in || !ok
+}
+
+// inIPv4Range returns true if ip belongs to from-to IPv4 range, ok is false if the range is invalid.
+func inIPv4Range(from, to, ip string) (in bool, ok bool) {
+	fromIP, toIP := net.ParseIP(from).To4(), net.ParseIP(to).To4()
+	if fromIP == nil || toIP == nil {
+		return false, false
+	}
+
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return false, true
+	}
+
+	return bytes.Compare(addr, fromIP) >= 0 && bytes.Compare(addr, toIP) <= 0, true
+}
+
+// freeIP returns the first address of the IPv4 range block which is not allocated, not the gateway and not taken.
+func (b ipBlockInfo) freeIP(taken map[string]bool) (string, error) {
+	if b.Type != solus.IPv4 || b.ListType != solus.IpBlockListTypeRange {
+		return "", fmt.Errorf("IP block %q is not an IPv4 range, set primary_ip in the plan", b.Name)
+	}
+
+	from, to := net.ParseIP(b.From).To4(), net.ParseIP(b.To).To4()
+	if from == nil || to == nil {
+		return "", fmt.Errorf("IP block %q has invalid range %s-%s", b.Name, b.From, b.To)
+	}
+
+	allocated := map[string]bool{b.Gateway: true}
+	for _, ip := range b.IPs {
+		allocated[ip.IP] = true
+	}
+
+	// Counter is 64-bit, so the loop ends after 255.255.255.255.
+	for n := uint64(binary.BigEndian.Uint32(from)); n <= uint64(binary.BigEndian.Uint32(to)); n++ {
+		addr := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(addr, uint32(n))
+
+		if ip := addr.String(); !allocated[ip] && !taken[ip] {
+			return ip, nil
+		}
+	}
+
+	return "", fmt.Errorf("IP block %q has no free addresses", b.Name)
+}
+
+// primaryIPAllocator picks primary IPs of virtual servers from IP blocks of their primary interfaces.
+type primaryIPAllocator struct {
+	settings ImportSettings
+	blocks   map[int]ipBlockInfo
+	// taken are primary IPs of the plan and already picked ones.
+	taken map[string]bool
+}
+
+func newPrimaryIPAllocator(settings ImportSettings, plan ImportPlan) *primaryIPAllocator {
+	a := &primaryIPAllocator{
+		settings: settings,
+		blocks:   map[int]ipBlockInfo{},
+		taken:    map[string]bool{},
+	}
+
+	for _, vs := range plan.VirtualServers {
+		if vs.PrimaryIP != nil {
+			a.taken[*vs.PrimaryIP] = true
+		}
+	}
+
+	return a
+}
+
+// primaryIP returns primary IP of the virtual server from the plan, or a free address of the IP block
+// mapped to the primary interface. Nothing is returned if portgroup mapping is not configured.
+func (a *primaryIPAllocator) primaryIP(vs VirtualServer) (*string, error) {
+	if vs.PrimaryIP != nil {
+		return vs.PrimaryIP, nil
+	}
+
+	mappings, err := vs.networkMappings(a.settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+
+	id := mappings[0].IPBlockID
+	b, ok := a.blocks[id]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+		err := solusAPIGet(ctx, a.settings, fmt.Sprintf("ip_blocks/%d", id), &b)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("get IP block %d: %w", id, err)
+		}
+		a.blocks[id] = b
+	}
+
+	ip, err := b.freeIP(a.taken)
+	if err != nil {
+		return nil, fmt.Errorf("virtual server's %s primary IP: %w", vs.Hostname, err)
+	}
+	a.taken[ip] = true
+
+	return &ip, nil
+}
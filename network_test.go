@@ -0,0 +1,128 @@
+package main
+
+import (
+	vmx "github.com/solusio/import-vmware/govmx"
+	"github.com/solusio/solus-go-sdk"
+	"reflect"
+	"testing"
+)
+
+func TestNetworkInterfacesFromVMX(t *testing.T) {
+	ethernet := []vmx.Ethernet{
+		{VMXID: "ethernet10", Present: true, NetworkName: "Backup", VirtualDev: "e1000e", AddressType: vmx.MAC_TYPE_GENERATED, GeneratedAddress: "00:0c:29:00:00:0a"},
+		{VMXID: "ethernet2", Present: false, NetworkName: "Removed"},
+		{VMXID: "ethernet0", Present: true, NetworkName: "VM Network", VirtualDev: "vmxnet3", AddressType: vmx.MAC_TYPE_STATIC, Address: "00:50:56:00:00:01", GeneratedAddress: "00:0c:29:00:00:01"},
+		{VMXID: "ethernet1", Present: true, NetworkName: "Private", VirtualDev: "vmxnet3", GeneratedAddress: "00:0c:29:00:00:02"},
+	}
+
+	want := []NetworkInterface{
+		{Device: "ethernet0", NetworkName: "VM Network", VirtualDev: "vmxnet3", MacAddress: "00:50:56:00:00:01"},
+		{Device: "ethernet1", NetworkName: "Private", VirtualDev: "vmxnet3", MacAddress: "00:0c:29:00:00:02"},
+		{Device: "ethernet10", NetworkName: "Backup", VirtualDev: "e1000e", MacAddress: "00:0c:29:00:00:0a"},
+	}
+	if got := networkInterfacesFromVMX(ethernet); !reflect.DeepEqual(got, want) {
+		t.Errorf("network interfaces are %+v, expected %+v", got, want)
+	}
+}
+
+func TestNetworkMappings(t *testing.T) {
+	vs := VirtualServer{
+		Hostname: "vm",
+		NetworkInterfaces: []NetworkInterface{
+			{Device: "ethernet0", NetworkName: "VM Network"},
+			{Device: "ethernet1", NetworkName: "Private"},
+		},
+	}
+
+	var settings ImportSettings
+	if mappings, err := vs.networkMappings(settings); err != nil || mappings != nil {
+		t.Errorf("expected no mappings without portgroup mapping, got %v, %v", mappings, err)
+	}
+	if count := vs.additionalIPCount(settings); count != nil {
+		t.Errorf("expected no additional IP count without portgroup mapping, got %d", *count)
+	}
+
+	settings.Defaults.PortgroupToNetwork = map[string]NetworkMapping{
+		"VM Network": {IPBlockID: 1},
+		"Private":    {IPBlockID: 2, VLAN: 20},
+	}
+	mappings, err := vs.networkMappings(settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []NetworkMapping{{IPBlockID: 1}, {IPBlockID: 2, VLAN: 20}}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("mappings are %+v, expected %+v", mappings, want)
+	}
+	if count := vs.additionalIPCount(settings); count == nil || *count != 1 {
+		t.Errorf("expected 1 additional IP, got %v", count)
+	}
+
+	delete(settings.Defaults.PortgroupToNetwork, "Private")
+	if _, err := vs.networkMappings(settings); err == nil {
+		t.Errorf("expected error for unmapped portgroup")
+	}
+}
+
+func TestIPBlockContains(t *testing.T) {
+	b := ipBlockInfo{
+		Type:     solus.IPv4,
+		ListType: solus.IpBlockListTypeRange,
+		From:     "192.168.1.10",
+		To:       "192.168.1.20",
+	}
+
+	for ip, want := range map[string]bool{
+		"192.168.1.10": true,
+		"192.168.1.15": true,
+		"192.168.1.20": true,
+		"192.168.1.9":  false,
+		"192.168.1.21": false,
+		"invalid":      false,
+	} {
+		if got := b.contains(ip); got != want {
+			t.Errorf("contains(%q) = %v, expected %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPBlockFreeIP(t *testing.T) {
+	b := ipBlockInfo{
+		Name:     "public",
+		Type:     solus.IPv4,
+		ListType: solus.IpBlockListTypeRange,
+		From:     "192.168.1.1",
+		To:       "192.168.1.4",
+		Gateway:  "192.168.1.1",
+		IPs:      []ipBlockAddress{{IP: "192.168.1.2"}},
+	}
+
+	ip, err := b.freeIP(map[string]bool{"192.168.1.3": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ip != "192.168.1.4" {
+		t.Errorf("expected free IP 192.168.1.4, got %s", ip)
+	}
+
+	if _, err := b.freeIP(map[string]bool{"192.168.1.3": true, "192.168.1.4": true}); err == nil {
+		t.Errorf("expected error for IP block without free addresses")
+	}
+}
+
+func TestPrimaryIPAllocatorKeepsPlanIP(t *testing.T) {
+	ip := "10.0.0.10"
+	vs := VirtualServer{Hostname: "vm", PrimaryIP: &ip}
+
+	a := newPrimaryIPAllocator(ImportSettings{}, ImportPlan{VirtualServers: []VirtualServer{vs}})
+	got, err := a.primaryIP(vs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got == nil || *got != ip {
+		t.Errorf("expected primary IP %s, got %v", ip, got)
+	}
+	if !a.taken[ip] {
+		t.Errorf("primary IP %s of the plan is expected to be taken", ip)
+	}
+}
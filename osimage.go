@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/solusio/solus-go-sdk"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// solusAPITokenEnv is an environment variable of SolusVM 2 API token used to create settings file,
+// the token is not passed as an option to keep it out of process list and shell history.
+const solusAPITokenEnv = "SOLUSVM_API_TOKEN"
+
+// solusAPIToken returns SolusVM 2 API token from the environment or prompts for it if ask is set.
+func solusAPIToken(ask bool) (string, error) {
+	token := os.Getenv(solusAPITokenEnv)
+	if token == "" && ask {
+		var err error
+		token, err = promptSecret("SolusVM 2 API token: ")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if token == "" {
+		return "", fmt.Errorf("set %s environment variable or use option -ask-api-token", solusAPITokenEnv)
+	}
+	return token, nil
+}
+
+// osImageVersionRef is an OS image version available in SolusVM 2, it's referenced in settings
+// by name like "Ubuntu 22.04".
+type osImageVersionRef struct {
+	ID    int
+	Name  string
+	Image string
+}
+
+// listOSImageVersions returns all KVM OS image versions of SolusVM 2.
+func listOSImageVersions(client *solus.Client) ([]osImageVersionRef, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	defer cancel()
+
+	resp, err := client.OsImages.List(ctx, &solus.FilterOsImages{})
+	if err != nil {
+		return nil, fmt.Errorf("list OS images: %w", err)
+	}
+
+	var refs []osImageVersionRef
+	for {
+		for _, image := range resp.Data {
+			for _, v := range image.Versions {
+				if v.VirtualizationType != "" && v.VirtualizationType != solus.VirtualizationTypeKVM {
+					continue
+				}
+
+				refs = append(refs, osImageVersionRef{
+					ID:    v.ID,
+					Name:  strings.TrimSpace(image.Name + " " + v.Version),
+					Image: image.Name,
+				})
+			}
+		}
+
+		if !resp.Next(ctx) {
+			break
+		}
+	}
+	if err := resp.Err(); err != nil {
+		return nil, fmt.Errorf("list OS images: %w", err)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+
+	return refs, nil
+}
+
+// findOSImageVersion returns ID of OS image version by name like "Ubuntu 22.04", name is case-insensitive.
+// An image name without version is accepted if the image has the only version.
+func findOSImageVersion(refs []osImageVersionRef, name string) (int, error) {
+	name = strings.TrimSpace(name)
+
+	var byImage []osImageVersionRef
+	for _, r := range refs {
+		if strings.EqualFold(r.Name, name) {
+			return r.ID, nil
+		}
+		if strings.EqualFold(r.Image, name) {
+			byImage = append(byImage, r)
+		}
+	}
+
+	switch len(byImage) {
+	case 0:
+		return 0, fmt.Errorf("OS image %q is not found", name)
+	case 1:
+		return byImage[0].ID, nil
+	default:
+		return 0, fmt.Errorf("OS image %q has %d versions, set the version like %q", name, len(byImage), byImage[0].Name)
+	}
+}
+
+// hasOSImageNames returns true if OS images are referenced by name in settings.
+func (d Defaults) hasOSImageNames() bool {
+	if d.DefaultOSImage != "" {
+		return true
+	}
+	for _, name := range d.GuestOSToOSImage {
+		if name != "" {
+			return true
+		}
+	}
+	for _, r := range d.GuestOSRules {
+		if r.OSImage != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveOSImageNames replaces OS images referenced by name in settings with OS image version IDs.
+func resolveOSImageNames(client *solus.Client, d *Defaults) error {
+	if !d.hasOSImageNames() {
+		return nil
+	}
+
+	refs, err := listOSImageVersions(client)
+	if err != nil {
+		return err
+	}
+
+	resolve := func(name string) (int, error) {
+		id, err := findOSImageVersion(refs, name)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("resolved OS image %q: version ID %d", name, id)
+		return id, nil
+	}
+
+	guestOSToID := make(map[string]int, len(d.GuestOSToOSImageVersionID)+len(d.GuestOSToOSImage))
+	for guestOS, id := range d.GuestOSToOSImageVersionID {
+		guestOSToID[guestOS] = id
+	}
+	for guestOS, name := range d.GuestOSToOSImage {
+		if name == "" {
+			continue
+		}
+		if guestOSToID[guestOS], err = resolve(name); err != nil {
+			return fmt.Errorf("guest_os_to_os_image[%q]: %w", guestOS, err)
+		}
+	}
+	d.GuestOSToOSImageVersionID = guestOSToID
+	d.GuestOSToOSImage = nil
+
+	rules := make([]GuestOSRule, len(d.GuestOSRules))
+	copy(rules, d.GuestOSRules)
+	for i, r := range rules {
+		if r.OSImage == "" {
+			continue
+		}
+		if rules[i].OSImageVersionID, err = resolve(r.OSImage); err != nil {
+			return fmt.Errorf("guest_os_rules[%d]: %w", i, err)
+		}
+		rules[i].OSImage = ""
+	}
+	d.GuestOSRules = rules
+
+	if d.DefaultOSImage != "" {
+		if d.DefaultOSImageVersionID, err = resolve(d.DefaultOSImage); err != nil {
+			return fmt.Errorf("default_os_image: %w", err)
+		}
+		d.DefaultOSImage = ""
+	}
+
+	return nil
+}
+
+// printOSImageCandidates prints available OS images next to every guest OS.
+func printOSImageCandidates(guestOSes []string, refs []osImageVersionRef) {
+	names := make([]string, 0, len(refs))
+	for _, r := range refs {
+		names = append(names, fmt.Sprintf("%q", r.Name))
+	}
+
+	sort.Strings(guestOSes)
+	for _, guestOS := range guestOSes {
+		candidates := osImageCandidates(guestOS, refs)
+		if len(candidates) == 0 {
+			fmt.Printf("Guest OS %q: no matching OS images\n", guestOS)
+			continue
+		}
+
+		quoted := make([]string, 0, len(candidates))
+		for _, c := range candidates {
+			quoted = append(quoted, fmt.Sprintf("%q", c))
+		}
+		fmt.Printf("Guest OS %q: %s\n", guestOS, strings.Join(quoted, ", "))
+	}
+
+	fmt.Printf("Available OS images: %s\n", strings.Join(names, ", "))
+}
+
+// osImageCandidates returns names of OS image versions which image first word is a part of guest OS,
+// like "Windows Server 2019" for windows2019srv-64. Versions which number is a part of guest OS too
+// are listed first.
+func osImageCandidates(guestOS string, refs []osImageVersionRef) []string {
+	guestOS = strings.ToLower(guestOS)
+
+	var exact, other []string
+	for _, r := range refs {
+		words := strings.Fields(strings.ToLower(r.Image))
+		if len(words) == 0 || !strings.Contains(guestOS, words[0]) {
+			continue
+		}
+
+		version := strings.ReplaceAll(strings.TrimSpace(strings.ToLower(strings.TrimPrefix(r.Name, r.Image))), " ", "")
+		if version != "" && strings.Contains(guestOS, version) {
+			exact = append(exact, r.Name)
+		} else {
+			other = append(other, r.Name)
+		}
+	}
+
+	return append(exact, other...)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindOSImageVersion(t *testing.T) {
+	refs := []osImageVersionRef{
+		{ID: 5, Name: "Ubuntu 22.04 Minimal", Image: "Ubuntu 22.04"},
+		{ID: 1, Name: "Debian 11", Image: "Debian"},
+		{ID: 2, Name: "Debian 12", Image: "Debian"},
+		{ID: 3, Name: "Ubuntu 22.04", Image: "Ubuntu"},
+		{ID: 4, Name: "AlmaLinux 9", Image: "AlmaLinux"},
+	}
+
+	tests := []struct {
+		name    string
+		want    int
+		wantErr string
+	}{
+		{name: "Debian 12", want: 2},
+		{name: "  debian 11 ", want: 1},
+		// Full name of a version takes precedence over an image with the only version.
+		{name: "Ubuntu 22.04", want: 3},
+		{name: "Ubuntu 22.04 minimal", want: 5},
+		{name: "almalinux", want: 4},
+		{name: "Debian", wantErr: `OS image "Debian" has 2 versions, set the version like "Debian 11"`},
+		{name: "CentOS 7", wantErr: `OS image "CentOS 7" is not found`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findOSImageVersion(refs, tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("findOSImageVersion(%q) = %d, expected %d", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOSImageNames(t *testing.T) {
+	api := newTestSolusAPI(t)
+	api.respond("GET /api/v1/os_images", []map[string]interface{}{
+		{"id": 1, "name": "Debian", "versions": []map[string]interface{}{
+			{"id": 1, "version": "11", "virtualization_type": "kvm"},
+			{"id": 2, "version": "12", "virtualization_type": "kvm"},
+		}},
+		{"id": 2, "name": "Ubuntu", "versions": []map[string]interface{}{
+			{"id": 3, "version": "22.04", "virtualization_type": "kvm"},
+			{"id": 6, "version": "22.04", "virtualization_type": "vz"},
+		}},
+		{"id": 3, "name": "AlmaLinux", "versions": []map[string]interface{}{
+			{"id": 4, "version": "9", "virtualization_type": "kvm"},
+		}},
+	})
+
+	client, err := newSolusClient(api.settings())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := Defaults{
+		GuestOSToOSImageVersionID: map[string]int{"ubuntu-64": 6, "debian11-64": 1},
+		GuestOSToOSImage:          map[string]string{"ubuntu-64": "Ubuntu 22.04"},
+		GuestOSRules:              []GuestOSRule{{GuestOS: "debian12-64", OSImage: "debian 12"}, {GuestOS: "centos-64", OSImageVersionID: 7}},
+		DefaultOSImage:            "AlmaLinux",
+	}
+	if err := resolveOSImageNames(client, &d); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// OS image name takes precedence over OS image version ID of the same guest OS, and a KVM version is picked.
+	if got := d.GuestOSToOSImageVersionID["ubuntu-64"]; got != 3 {
+		t.Errorf("ubuntu-64 is resolved to %d, expected 3", got)
+	}
+	if got := d.GuestOSToOSImageVersionID["debian11-64"]; got != 1 {
+		t.Errorf("debian11-64 is resolved to %d, expected 1", got)
+	}
+	if d.GuestOSRules[0].OSImageVersionID != 2 || d.GuestOSRules[0].OSImage != "" {
+		t.Errorf("rule 0 is resolved to %d %q, expected 2", d.GuestOSRules[0].OSImageVersionID, d.GuestOSRules[0].OSImage)
+	}
+	if d.GuestOSRules[1].OSImageVersionID != 7 {
+		t.Errorf("rule 1 is resolved to %d, expected 7", d.GuestOSRules[1].OSImageVersionID)
+	}
+	if d.DefaultOSImageVersionID != 4 || d.DefaultOSImage != "" {
+		t.Errorf("default OS image is resolved to %d %q, expected 4", d.DefaultOSImageVersionID, d.DefaultOSImage)
+	}
+	if d.GuestOSToOSImage != nil {
+		t.Errorf("OS image names are not cleared: %v", d.GuestOSToOSImage)
+	}
+}
+
+func TestOSImageCandidates(t *testing.T) {
+	refs := []osImageVersionRef{
+		{ID: 1, Name: "Debian 11", Image: "Debian"},
+		{ID: 2, Name: "Debian 12", Image: "Debian"},
+		{ID: 3, Name: "Windows Server 2022", Image: "Windows Server"},
+		{ID: 4, Name: "Windows Server 2019", Image: "Windows Server"},
+		{ID: 5, Name: "CentOS Stream 9", Image: "CentOS Stream"},
+		{ID: 6, Name: "Ubuntu 22.04", Image: "Ubuntu"},
+	}
+
+	tests := map[string][]string{
+		"windows2019srv-64":     {"Windows Server 2019", "Windows Server 2022"},
+		"windows2019srvNext-64": {"Windows Server 2019", "Windows Server 2022"},
+		"debian12-64":           {"Debian 12", "Debian 11"},
+		"centos9-64":            {"CentOS Stream 9"},
+		"ubuntu-64":             {"Ubuntu 22.04"},
+		"freebsd-64":            nil,
+	}
+
+	for guestOS, want := range tests {
+		if got := osImageCandidates(guestOS, refs); !equalStrings(got, want) {
+			t.Errorf("osImageCandidates(%q) = %q, expected %q", guestOS, got, want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/solusio/import-vmware/common"
 	"github.com/solusio/solus-go-sdk"
 	"os"
+	"path/filepath"
 )
 
 type ImportPlan struct {
@@ -14,24 +15,38 @@ type ImportPlan struct {
 }
 
 type VirtualServer struct {
-	VMXFilePath                string          `json:"vmx_file_path,omitempty"`
-	VirtualServerID            int             `json:"virtual_server_id,omitempty"`
-	VirtualServerUUID          string          `json:"virtual_server_uuid,omitempty"`
-	OriginDir                  string          `json:"origin_dir,omitempty"`
-	OriginName                 string          `json:"origin_name,omitempty"`
-	Hostname                   string          `json:"hostname,omitempty"`
-	ComputeResourceID          int             `json:"compute_resource_id,omitempty"`
-	GuestOS                    string          `json:"guest_os,omitempty"`
-	CustomPlan                 solus.Plan      `json:"custom_plan"`
-	PrimaryDiskSourcePath      string          `json:"primary_disk_source_path,omitempty"`
-	PrimaryDiskDestinationPath string          `json:"primary_disk_destination_path,omitempty"`
-	AdditionalDisks            []Disk          `json:"additional_disks,omitempty"`
-	PrimaryIP                  *string         `json:"primary_ip,omitempty"`
-	AdditionalIPv4             *int            `json:"additional_ipv4,omitempty"`
-	Password                   string          `json:"password,omitempty"`
-	SSHKeys                    []int           `json:"ssh_keys,omitempty"`
-	MacAddress                 *string         `json:"mac_address,omitempty"`
-	Firmware                   *solus.Firmware `json:"firmware,omitempty"`
+	VMXFilePath                string             `json:"vmx_file_path,omitempty"`
+	VirtualServerID            int                `json:"virtual_server_id,omitempty"`
+	VirtualServerUUID          string             `json:"virtual_server_uuid,omitempty"`
+	OriginDir                  string             `json:"origin_dir,omitempty"`
+	SourceHost                 string             `json:"source_host,omitempty"`
+	Datastore                  string             `json:"datastore,omitempty"`
+	DatastoreUUID              string             `json:"datastore_uuid,omitempty"`
+	OriginName                 string             `json:"origin_name,omitempty"`
+	Hostname                   string             `json:"hostname,omitempty"`
+	ComputeResourceID          int                `json:"compute_resource_id,omitempty"`
+	GuestOS                    string             `json:"guest_os,omitempty"`
+	Annotation                 string             `json:"annotation,omitempty"`
+	CustomPlan                 solus.Plan         `json:"custom_plan"`
+	PrimaryDiskSourcePath      string             `json:"primary_disk_source_path,omitempty"`
+	PrimaryDiskDestinationPath string             `json:"primary_disk_destination_path,omitempty"`
+	PrimaryDisk                *Disk              `json:"primary_disk,omitempty"`
+	PrimaryDiskDevice          string             `json:"primary_disk_device,omitempty"`
+	AdditionalDisks            []Disk             `json:"additional_disks,omitempty"`
+	PrimaryIP                  *string            `json:"primary_ip,omitempty"`
+	AdditionalIPv4             *int               `json:"additional_ipv4,omitempty"`
+	Password                   string             `json:"password,omitempty"`
+	SSHKeys                    []int              `json:"ssh_keys,omitempty"`
+	MacAddress                 *string            `json:"mac_address,omitempty"`
+	NetworkInterfaces          []NetworkInterface `json:"network_interfaces,omitempty"`
+	GuestIPs                   []GuestIP          `json:"guest_ips,omitempty"`
+	Firmware                   *solus.Firmware    `json:"firmware,omitempty"`
+	SnapshotMode               string             `json:"snapshot_mode,omitempty"`
+	PowerState                 PowerState         `json:"power_state,omitempty"`
+	ShutdownIssues             []string           `json:"shutdown_issues,omitempty"`
+	ImportState                ImportState        `json:"import_state,omitempty"`
+	ImportResumeState          ImportState        `json:"import_resume_state,omitempty"`
+	ImportError                string             `json:"import_error,omitempty"`
 }
 
 type Disk struct {
@@ -40,6 +55,32 @@ type Disk struct {
 	Size            int    `json:"size,omitempty"`
 	SourcePath      string `json:"source_path,omitempty"`
 	DestinationPath string `json:"destination_path,omitempty"`
+
+	// Device is a VMX device ID of the disk like scsi0:0.
+	Device string `json:"device,omitempty"`
+	// VMXFilename is a disk file name as it's referenced in VMX file.
+	VMXFilename string `json:"vmx_filename,omitempty"`
+	// Datastore is a datastore name of the disk, it may differ from the virtual server datastore.
+	Datastore string `json:"datastore,omitempty"`
+
+	// Fields below are taken from VMDK descriptor.
+	CapacityBytes    int64    `json:"capacity_bytes,omitempty"`
+	CreateType       string   `json:"create_type,omitempty"`
+	ProvisioningType string   `json:"provisioning_type,omitempty"`
+	Extents          []string `json:"extents,omitempty"`
+
+	// SnapshotChain contains descriptors paths from the current state to the base disk.
+	// It's empty for a disk without snapshots.
+	SnapshotChain []string `json:"snapshot_chain,omitempty"`
+}
+
+// MatchesVMDir returns true if the virtual server is stored in vmDir directory.
+// Directory may be specified either by name or by full path, empty vmDir matches any virtual server.
+func (vs VirtualServer) MatchesVMDir(vmDir string) bool {
+	if vmDir == "" {
+		return true
+	}
+	return vs.OriginDir == vmDir || filepath.Base(vs.OriginDir) == vmDir
 }
 
 func (i *ImportPlan) Validate() error {
@@ -53,14 +94,38 @@ func (i *ImportPlan) Validate() error {
 		return fmt.Errorf("API token is not set")
 	}
 
+	for n, r := range i.Settings.Defaults.GuestOSRules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("guest OS rule %d: %w", n, err)
+		}
+	}
+
 	for _, vs := range i.VirtualServers {
-		imageID, ok := i.Settings.Defaults.GuestOSToOSImageVersionID[vs.GuestOS]
-		if !ok {
-			return fmt.Errorf("virtual server's %s guest OS %s does not exist in settings guest_os_to_os_image_version_id", vs.Hostname, vs.GuestOS)
+		guestOS, err := resolveGuestOS(i.Settings.Defaults, vs)
+		if err != nil {
+			return fmt.Errorf("virtual server's %s guest OS %s: %w", vs.Hostname, vs.GuestOS, err)
+		}
+
+		if !guestOS.isMapped() {
+			return fmt.Errorf("virtual server's %s guest OS %s is not mapped to OS image in settings "+
+				"guest_os_to_os_image_version_id, guest_os_to_os_image, guest_os_rules or default OS image", vs.Hostname, vs.GuestOS)
 		}
 
-		if imageID == 0 {
-			return fmt.Errorf("virtual server's %s guest OS %s does not mapped to OS image ID in settings guest_os_to_os_image_version_id", vs.Hostname, vs.GuestOS)
+		if _, err := vs.networkMappings(i.Settings); err != nil {
+			return err
+		}
+
+		if err := vs.validateSnapshots(); err != nil {
+			return err
+		}
+
+		for _, disk := range vs.disksInConversionOrder() {
+			if disk.CreateType == "" {
+				continue
+			}
+			if err := checkImportableCreateType(disk.CreateType); err != nil {
+				return fmt.Errorf("virtual server's %s disk %s: %w", vs.Hostname, disk.SourcePath, err)
+			}
 		}
 
 		if vs.ComputeResourceID == 0 && i.Settings.Defaults.ComputeResourceID == 0 {
@@ -97,17 +162,28 @@ func (i *ImportPlan) Validate() error {
 	return nil
 }
 
+// saveImportPlan writes the plan to a temporary file and renames it, so the plan
+// is not corrupted if the process is interrupted in the middle of writing.
 func saveImportPlan(importPlanFilePath string, plan ImportPlan) error {
-	f, err := os.OpenFile(importPlanFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	tmpPath := importPlanFilePath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("open file %q: %w", importPlanFilePath, err)
+		return fmt.Errorf("open file %q: %w", tmpPath, err)
 	}
 
-	defer common.CloseWrapper(f)
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(plan); err != nil {
-		return fmt.Errorf("failed to write import plan file %s: %v", importPlanFilePath, err)
+		common.CloseWrapper(f)
+		return fmt.Errorf("failed to write import plan file %s: %v", tmpPath, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %v", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, importPlanFilePath); err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %v", tmpPath, importPlanFilePath, err)
 	}
 
 	return nil
@@ -127,5 +203,11 @@ func loadImportPlan(importPlanFilePath string) (ImportPlan, error) {
 		return plan, fmt.Errorf("failed to close %q: %v", importPlanFilePath, err)
 	}
 
+	for i := range plan.VirtualServers {
+		if err := plan.VirtualServers[i].applyPrimaryDiskDevice(); err != nil {
+			return plan, err
+		}
+	}
+
 	return plan, nil
 }
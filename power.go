@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solusio/import-vmware/ssh"
+	"log"
+	"strings"
+	"time"
+)
+
+// PowerState is a power state of a virtual machine on ESXi host.
+type PowerState string
+
+const (
+	PowerStateOn        PowerState = "powered-on"
+	PowerStateOff       PowerState = "powered-off"
+	PowerStateSuspended PowerState = "suspended"
+)
+
+// What to do with a running virtual machine before disks import.
+const (
+	RunningVMActionRefuse   = "refuse"
+	RunningVMActionSkip     = "skip"
+	RunningVMActionShutdown = "shutdown"
+)
+
+const powerStatePollInterval = 5 * time.Second
+
+// errVirtualServerSkipped is returned when a virtual server import is skipped, it's not a failure.
+var errVirtualServerSkipped = errors.New("virtual server import is skipped")
+
+func isValidRunningVMAction(action string) bool {
+	switch action {
+	case RunningVMActionRefuse, RunningVMActionSkip, RunningVMActionShutdown:
+		return true
+	}
+	return false
+}
+
+// runningVMOptions describes what to do with a running virtual machine before disks import.
+type runningVMOptions struct {
+	Action string
+	// ShutdownTimeout is a time to wait for a guest shutdown.
+	ShutdownTimeout time.Duration
+	// ForcePowerOff powers off a virtual machine which is not shut down in ShutdownTimeout.
+	ForcePowerOff bool
+}
+
+// getPowerState returns a power state of ESXi VM.
+func getPowerState(node ssh.NodeConnection, id int) (PowerState, error) {
+	out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/power.getstate %d", id))
+	if err != nil {
+		return "", fmt.Errorf("get power state of vm %d %s: %w", id, string(out), err)
+	}
+
+	state, ok := parsePowerState(out)
+	if !ok {
+		return "", fmt.Errorf("unknown power state of vm %d: %s", id, strings.TrimSpace(string(out)))
+	}
+	return state, nil
+}
+
+// parsePowerState parses `vim-cmd vmsvc/power.getstate` output like:
+// Retrieved runtime info
+// Powered on
+func parsePowerState(out []byte) (PowerState, bool) {
+	s := strings.ToLower(string(out))
+	switch {
+	case strings.Contains(s, "powered on"):
+		return PowerStateOn, true
+	case strings.Contains(s, "powered off"):
+		return PowerStateOff, true
+	case strings.Contains(s, "suspended"):
+		return PowerStateSuspended, true
+	default:
+		return "", false
+	}
+}
+
+// recordPowerStates records power states of virtual servers registered on the host to the plan.
+func recordPowerStates(node ssh.NodeConnection, vms *esxiVMIndex, plan *ImportPlan) error {
+	for i := range plan.VirtualServers {
+		vs := &plan.VirtualServers[i]
+
+		id, err := vms.lookup(vs.VMXFilePath)
+		if err != nil {
+			log.Printf("virtual server %q power state is unknown: %s", vs.OriginName, err)
+			continue
+		}
+
+		if vs.PowerState, err = getPowerState(node, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePoweredOff checks the virtual machine is powered off before disks import and handles
+// a running one according to opts.
+func ensurePoweredOff(node *ssh.NodeConnection, vs VirtualServer, opts runningVMOptions) error {
+	if node == nil {
+		return fmt.Errorf("virtual server %q power state check requires connection to the source host", vs.OriginName)
+	}
+
+	id, err := findESXiVMID(*node, vs.VMXFilePath)
+	if errors.Is(err, errNotRegistered) {
+		// A virtual machine which is not registered on the host can't be running.
+		log.Printf("virtual server %q power state is not checked: %s", vs.OriginName, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("virtual server %q power state check: %w", vs.OriginName, err)
+	}
+
+	state, err := getPowerState(*node, id)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case PowerStateOff:
+		return nil
+	case PowerStateSuspended:
+		return fmt.Errorf("virtual server %q is suspended, resume and shut it down before import", vs.OriginName)
+	}
+
+	switch opts.Action {
+	case RunningVMActionSkip:
+		log.Printf("virtual server %q is running, skip it", vs.OriginName)
+		return errVirtualServerSkipped
+	case RunningVMActionShutdown:
+		return shutdownVM(*node, id, vs, opts)
+	default:
+		return fmt.Errorf("virtual server %q is running, shut it down or use another running VM action", vs.OriginName)
+	}
+}
+
+// shutdownVM shuts down the guest with VMware Tools and waits for it, then powers the virtual machine off
+// if it's allowed.
+func shutdownVM(node ssh.NodeConnection, id int, vs VirtualServer, opts runningVMOptions) error {
+	log.Printf("shut down virtual server %q", vs.OriginName)
+
+	out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/power.shutdown %d", id))
+	if err != nil {
+		// VMware Tools may be not installed, so the virtual machine is powered off if it's allowed.
+		log.Printf("failed to shut down virtual server %q %s: %s", vs.OriginName, string(out), err)
+	} else {
+		deadline := time.Now().Add(opts.ShutdownTimeout)
+		for time.Now().Before(deadline) {
+			time.Sleep(powerStatePollInterval)
+
+			state, err := getPowerState(node, id)
+			if err != nil {
+				return err
+			}
+			if state == PowerStateOff {
+				log.Printf("virtual server %q is shut down", vs.OriginName)
+				return nil
+			}
+		}
+	}
+
+	if !opts.ForcePowerOff {
+		return fmt.Errorf("virtual server %q is not shut down in %s", vs.OriginName, opts.ShutdownTimeout)
+	}
+
+	log.Printf("power off virtual server %q", vs.OriginName)
+	if out, err := node.Exec(fmt.Sprintf("vim-cmd vmsvc/power.off %d", id)); err != nil {
+		return fmt.Errorf("power off vm %d %s: %w", id, string(out), err)
+	}
+
+	return nil
+}
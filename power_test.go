@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePowerState(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		want   PowerState
+		wantOK bool
+	}{
+		{name: "powered on", out: "Retrieved runtime info\nPowered on\n", want: PowerStateOn, wantOK: true},
+		{name: "powered off", out: "Retrieved runtime info\nPowered off\n", want: PowerStateOff, wantOK: true},
+		{name: "suspended", out: "Retrieved runtime info\nSuspended\n", want: PowerStateSuspended, wantOK: true},
+		{name: "unknown", out: "Retrieved runtime info\n", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePowerState([]byte(tt.out))
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parsePowerState(%q) = %q, %v, expected %q, %v", tt.out, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/solusio/solus-go-sdk"
+	"log"
+	"time"
+)
+
+const (
+	taskPollInterval = 5 * time.Second
+	taskTimeout      = 30 * time.Minute
+)
+
+// rollbackVirtualServers deletes SolusVM 2 virtual servers created by the plan and clears
+// their IDs and disks destination paths in the plan. Failure of a virtual server does not
+// stop rollback of others.
+func rollbackVirtualServers(settings ImportSettings, importPlanFilePath, vmDir string) error {
+	plan, err := loadImportPlan(importPlanFilePath)
+	if err != nil {
+		return err
+	}
+
+	plan.Settings = settings
+
+	client, err := newSolusClient(plan.Settings)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, vs := range plan.VirtualServers {
+		if vs.VirtualServerID == 0 || !vs.MatchesVMDir(vmDir) {
+			continue
+		}
+
+		if err := deleteVirtualServer(client, vs.VirtualServerID); err != nil {
+			errs = append(errs, fmt.Errorf("delete virtual server %q ID %d: %w", vs.Hostname, vs.VirtualServerID, err))
+			continue
+		}
+
+		fmt.Printf("Virtual server %q ID %d deleted\n", vs.Hostname, vs.VirtualServerID)
+
+		plan.VirtualServers[i].resetCreated()
+
+		if err := saveImportPlan(importPlanFilePath, plan); err != nil {
+			return fmt.Errorf("save import plan: %w", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resetCreated clears everything set to the virtual server after it was created in SolusVM 2.
+func (vs *VirtualServer) resetCreated() {
+	vs.VirtualServerID = 0
+	vs.VirtualServerUUID = ""
+	vs.PrimaryDiskDestinationPath = ""
+	for i := range vs.AdditionalDisks {
+		vs.AdditionalDisks[i].DestinationPath = ""
+	}
+	vs.ImportState = ImportStatePlanned
+	vs.ImportResumeState = ""
+	vs.ImportError = ""
+}
+
+// deleteVirtualServer deletes virtual server and waits for the delete task completion.
+// Already deleted virtual server is not an error.
+func deleteVirtualServer(client *solus.Client, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	task, err := client.VirtualServers.Delete(ctx, id)
+	cancel()
+	if err != nil {
+		if solus.IsNotFound(err) {
+			log.Printf("virtual server %d is already deleted", id)
+			return nil
+		}
+		return err
+	}
+
+	return waitForTask(client, task)
+}
+
+// waitForTask polls the task until it is finished.
+func waitForTask(client *solus.Client, task solus.Task) error {
+	deadline := time.Now().Add(taskTimeout)
+
+	for !task.IsFinished() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("task %d is not finished within %s, last status is %q", task.ID, taskTimeout, task.Status)
+		}
+
+		time.Sleep(taskPollInterval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+		t, err := client.Tasks.Get(ctx, task.ID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("get task %d: %w", task.ID, err)
+		}
+		task = t
+	}
+
+	if task.Status != solus.TaskStatusDone {
+		return fmt.Errorf("task %d %s finished with status %q: %s", task.ID, task.Action, task.Status, task.Output)
+	}
+
+	return nil
+}
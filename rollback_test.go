@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRollbackVirtualServers(t *testing.T) {
+	api := newTestSolusAPI(t)
+	api.respond("DELETE /api/v1/servers/42", map[string]interface{}{"id": 7, "status": "done"})
+	// Virtual server 43 is already deleted, the stub responds 404.
+
+	created := testTwoDiskVirtualServer()
+	created.Hostname = "vm"
+	created.VirtualServerID = 42
+	created.VirtualServerUUID = "5c8a2f4e"
+	created.ImportState = ImportStateFailed
+	created.ImportResumeState = ImportStateConverted
+	created.ImportError = "virt-v2v failed"
+
+	deleted := testTwoDiskVirtualServer()
+	deleted.Hostname = "deleted"
+	deleted.VirtualServerID = 43
+	deleted.ImportState = ImportStateCreated
+
+	planned := testTwoDiskVirtualServer()
+	planned.Hostname = "planned"
+	planned.PrimaryDiskDestinationPath = ""
+	planned.AdditionalDisks[0].DestinationPath = ""
+
+	path := testImportPlanFile(t, ImportPlan{VirtualServers: []VirtualServer{created, deleted, planned}})
+
+	if err := rollbackVirtualServers(api.settings(), path, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	plan, err := loadImportPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vs := range plan.VirtualServers {
+		if vs.VirtualServerID != 0 || vs.VirtualServerUUID != "" || vs.PrimaryDiskDestinationPath != "" || vs.AdditionalDisks[0].DestinationPath != "" {
+			t.Errorf("virtual server %q is not reset: %+v", vs.Hostname, vs)
+		}
+		if vs.CurrentImportState() != ImportStatePlanned || vs.ImportError != "" {
+			t.Errorf("virtual server %q import state is %q with error %q", vs.Hostname, vs.CurrentImportState(), vs.ImportError)
+		}
+	}
+
+	if n := api.count("DELETE /api/v1/servers/42") + api.count("DELETE /api/v1/servers/43"); n != 2 {
+		t.Errorf("%d virtual servers are deleted, expected 2", n)
+	}
+}
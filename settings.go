@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/solusio/import-vmware/common"
 	"os"
+	"strings"
 )
 
 const (
@@ -12,29 +13,83 @@ const (
 )
 
 type ImportSettings struct {
-	SourceIP string   `json:"source_ip"`
-	APIURL   string   `json:"api_url"`
-	APIToken string   `json:"api_token"`
-	Defaults Defaults `json:"defaults"`
+	SourceIP string `json:"source_ip"`
+	// SourceHosts is a list of ESXi hosts the import plan is created from, source_ip is used
+	// for virtual servers without source host.
+	SourceHosts []string `json:"source_hosts,omitempty"`
+	// SourceHostKeyFingerprints are optional pinned SHA256 fingerprints of source hosts SSH keys by host.
+	// Either every source host is pinned or none of them.
+	SourceHostKeyFingerprints map[string]string `json:"source_host_key_fingerprints,omitempty"`
+	// SourceHostKeyFingerprint is a single fingerprint of settings files created before multi-host support,
+	// it's rejected in favor of source_host_key_fingerprints.
+	SourceHostKeyFingerprint string   `json:"source_host_key_fingerprint,omitempty"`
+	APIURL                   string   `json:"api_url"`
+	APIToken                 string   `json:"api_token"`
+	Defaults                 Defaults `json:"defaults"`
+}
+
+// checkHostKeyFingerprints checks host keys of all hosts are pinned if any host key is pinned,
+// so a host is not verified with known hosts file by mistake.
+func (s ImportSettings) checkHostKeyFingerprints(hosts []string) error {
+	if s.SourceHostKeyFingerprint != "" {
+		return fmt.Errorf("source_host_key_fingerprint is not supported, set fingerprint of every source host in "+
+			"source_host_key_fingerprints like {%q: %q}", "<host>", s.SourceHostKeyFingerprint)
+	}
+
+	if len(s.SourceHostKeyFingerprints) == 0 {
+		return nil
+	}
+
+	var unpinned []string
+	for _, host := range hosts {
+		if s.SourceHostKeyFingerprints[host] == "" {
+			unpinned = append(unpinned, host)
+		}
+	}
+	if len(unpinned) > 0 {
+		return fmt.Errorf("host key fingerprint of source hosts %s is not set in source_host_key_fingerprints, "+
+			"pin every source host or none of them", strings.Join(unpinned, ", "))
+	}
+
+	return nil
 }
 
 type Defaults struct {
 	GuestOSToOSImageVersionID map[string]int `json:"guest_os_to_os_image_version_id"`
-	UserID                    int            `json:"user_id"`
-	ProjectID                 int            `json:"project_id"`
-	ComputeResourceID         int            `json:"compute_resource_id"`
-	LocationID                int            `json:"location_id"`
-	SSHKeys                   []int          `json:"ssh_keys"`
-	AdditionalDiskOfferID     int            `json:"additional_disk_offer_id"`
+	// GuestOSToOSImage maps guest OS to OS image name like "Ubuntu 22.04", it takes precedence
+	// over guest_os_to_os_image_version_id.
+	GuestOSToOSImage map[string]string `json:"guest_os_to_os_image,omitempty"`
+	// GuestOSRules are checked in order for guest OS which is not in guest_os_to_os_image_version_id.
+	GuestOSRules []GuestOSRule `json:"guest_os_rules,omitempty"`
+	// DefaultOSImageVersionID is used for virtual servers no rule matches.
+	DefaultOSImageVersionID int `json:"default_os_image_version_id,omitempty"`
+	// DefaultOSImage is an OS image name used for virtual servers no rule matches, it takes precedence
+	// over default_os_image_version_id.
+	DefaultOSImage string `json:"default_os_image,omitempty"`
+
+	UserID                int   `json:"user_id"`
+	ProjectID             int   `json:"project_id"`
+	ComputeResourceID     int   `json:"compute_resource_id"`
+	LocationID            int   `json:"location_id"`
+	SSHKeys               []int `json:"ssh_keys"`
+	AdditionalDiskOfferID int   `json:"additional_disk_offer_id"`
+	// PortgroupToNetwork maps ESXi portgroup of every network interface to SolusVM 2 network.
+	PortgroupToNetwork map[string]NetworkMapping `json:"portgroup_to_network,omitempty"`
 }
 
+// saveSettings writes settings to a file readable by the owner only, since it contains SolusVM 2 API token.
 func saveSettings(settingsFilePath string, settings ImportSettings) error {
-	f, err := os.OpenFile(settingsFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	f, err := os.OpenFile(settingsFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("open file %q: %w", settingsFilePath, err)
 	}
 
 	defer common.CloseWrapper(f)
+	// Mode is applied on creation only, an existing file may be readable by others.
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("change mode of file %q: %w", settingsFilePath, err)
+	}
+
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(settings); err != nil {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveSettingsIsReadableByOwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	// An existing file readable by others is restricted as well.
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveSettings(path, ImportSettings{APIURL: "https://solus.example.tld/api/v1/", APIToken: "token"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("settings file mode is %o, expected 600", mode)
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.APIToken != "token" {
+		t.Errorf("API token is %q, expected %q", settings.APIToken, "token")
+	}
+}
+
+func TestCheckHostKeyFingerprints(t *testing.T) {
+	hosts := []string{"192.168.192.168", "192.168.192.169"}
+
+	tests := []struct {
+		name     string
+		settings ImportSettings
+		wantErr  bool
+	}{
+		{name: "none pinned"},
+		{
+			name: "all pinned",
+			settings: ImportSettings{SourceHostKeyFingerprints: map[string]string{
+				"192.168.192.168": "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+				"192.168.192.169": "SHA256:Y3VwKwSqgTNVnJoOQ8Gxnu9N0jqErUSx3XYaeFiDU0Q",
+			}},
+		},
+		{
+			name: "some pinned",
+			settings: ImportSettings{SourceHostKeyFingerprints: map[string]string{
+				"192.168.192.168": "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+			}},
+			wantErr: true,
+		},
+		{
+			name:     "single fingerprint of old settings",
+			settings: ImportSettings{SourceHostKeyFingerprint: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.checkHostKeyFingerprints(hosts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkHostKeyFingerprints() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
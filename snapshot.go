@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"github.com/solusio/import-vmware/ssh"
+	"log"
+	"path/filepath"
+)
+
+// Snapshot modes define what to do with a virtual server which disks have snapshots.
+const (
+	// SnapshotModeFail fails import plan creation.
+	SnapshotModeFail = "fail"
+
+	// SnapshotModeCurrent imports the current (top of the chain) state of disks, snapshots are kept.
+	// virt-v2v refuses delta disks over SSH, so disks are imported from a temporary clone.
+	SnapshotModeCurrent = "current"
+
+	// SnapshotModeConsolidate removes all snapshots on ESXi host with
+	// `vim-cmd vmsvc/snapshot.removeall` before import, so the chain is merged
+	// into the base disks.
+	SnapshotModeConsolidate = "consolidate"
+)
+
+// vmdkMaxChainLength is a maximum number of snapshots in a chain supported by ESXi.
+const vmdkMaxChainLength = 32
+
+func isValidSnapshotMode(mode string) bool {
+	switch mode {
+	case SnapshotModeFail, SnapshotModeCurrent, SnapshotModeConsolidate:
+		return true
+	}
+	return false
+}
+
+// importsSnapshots returns true if disks with snapshots are imported in the mode.
+func importsSnapshots(mode string) bool {
+	return mode == SnapshotModeCurrent || mode == SnapshotModeConsolidate
+}
+
+// ResolveVMDKChain reads VMDK descriptor and all its parents.
+// Returned chain starts with the descriptor at path and ends with the base disk.
+func ResolveVMDKChain(fsys FS, path string) ([]VMDKDescriptor, error) {
+	d, err := ReadVMDKDescriptor(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []VMDKDescriptor{d}
+	visited := map[string]bool{d.Path: true}
+
+	for d.HasParent() {
+		if len(chain) > vmdkMaxChainLength {
+			return nil, fmt.Errorf("disk %q snapshot chain is longer than %d", path, vmdkMaxChainLength)
+		}
+
+		if d.ParentFileNameHint == "" {
+			return nil, fmt.Errorf("disk %q has parent CID %s but no parentFileNameHint", d.Path, d.ParentCID)
+		}
+
+		parentPath := d.ParentFileNameHint
+		if !filepath.IsAbs(parentPath) {
+			parentPath = filepath.Join(filepath.Dir(d.Path), parentPath)
+		}
+
+		if visited[parentPath] {
+			return nil, fmt.Errorf("disk %q snapshot chain has a loop at %q", path, parentPath)
+		}
+		visited[parentPath] = true
+
+		parent, err := ReadVMDKDescriptor(fsys, parentPath)
+		if err != nil {
+			return nil, fmt.Errorf("read parent of disk %q: %w", d.Path, err)
+		}
+
+		if parent.CID != d.ParentCID {
+			return nil, fmt.Errorf("disk %q parent CID %s does not match CID %s of %q, snapshot chain is broken",
+				d.Path, d.ParentCID, parent.CID, parent.Path)
+		}
+
+		chain = append(chain, parent)
+		d = parent
+	}
+
+	return chain, nil
+}
+
+// diskFromVMDKChain creates a disk from the chain returned by ResolveVMDKChain. Source path points
+// to the disk which is imported in the snapshot mode: the base disk if snapshots are consolidated,
+// the top of the chain otherwise. Provisioning details are taken from the base disk.
+func diskFromVMDKChain(chain []VMDKDescriptor, snapshotMode string) Disk {
+	top := chain[0]
+	base := chain[len(chain)-1]
+
+	disk := diskFromVMDKDescriptor(base)
+	if snapshotMode != SnapshotModeConsolidate {
+		disk.Name = top.Path
+		disk.SourcePath = top.Path
+	}
+	disk.Size = top.CapacityGiB()
+	disk.CapacityBytes = top.CapacityBytes()
+
+	if len(chain) > 1 {
+		for _, d := range chain {
+			disk.SnapshotChain = append(disk.SnapshotChain, d.Path)
+		}
+	}
+
+	return disk
+}
+
+// importSourcePath returns a path of the disk to import after its snapshots are handled in the mode.
+// Snapshot mode may be changed in the plan, so the path is taken from the snapshot chain.
+func (d Disk) importSourcePath(snapshotMode string) string {
+	if len(d.SnapshotChain) == 0 {
+		return d.SourcePath
+	}
+	if snapshotMode == SnapshotModeConsolidate {
+		return d.SnapshotChain[len(d.SnapshotChain)-1]
+	}
+	return d.SnapshotChain[0]
+}
+
+// HasSnapshots returns true if any disk of the virtual server has a snapshot chain.
+func (vs VirtualServer) HasSnapshots() bool {
+	if vs.PrimaryDisk != nil && len(vs.PrimaryDisk.SnapshotChain) > 0 {
+		return true
+	}
+
+	for _, d := range vs.AdditionalDisks {
+		if len(d.SnapshotChain) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errSnapshotsNotSupported returns an error for a virtual server with snapshots which are not imported in its mode.
+func errSnapshotsNotSupported(name string) error {
+	return fmt.Errorf("virtual server %q has snapshots, remove them or set snapshot mode to %q or %q",
+		name, SnapshotModeCurrent, SnapshotModeConsolidate)
+}
+
+// validateSnapshots returns an error if the virtual server has snapshots which are not imported
+// in its snapshot mode, so the plan is rejected before virtual servers are created.
+func (vs VirtualServer) validateSnapshots() error {
+	if vs.HasSnapshots() && !importsSnapshots(vs.SnapshotMode) {
+		return errSnapshotsNotSupported(vs.OriginName)
+	}
+	return nil
+}
+
+// snapshotModeOf returns snapshot mode to be stored in the plan, it's empty for
+// a virtual server without snapshots.
+func snapshotModeOf(primary Disk, additional []Disk, mode string) string {
+	vs := VirtualServer{PrimaryDisk: &primary, AdditionalDisks: additional}
+	if !vs.HasSnapshots() {
+		return ""
+	}
+	return mode
+}
+
+// prepareSnapshots handles snapshots of a virtual server according to its snapshot mode
+// before disks import.
+func prepareSnapshots(node *ssh.NodeConnection, vs VirtualServer) error {
+	if !vs.HasSnapshots() {
+		return nil
+	}
+
+	switch vs.SnapshotMode {
+	case SnapshotModeCurrent:
+		// The current state is cloned right before conversion.
+		return nil
+	case SnapshotModeConsolidate:
+		if node == nil {
+			return fmt.Errorf("virtual server %q snapshots consolidation requires connection to the source host", vs.OriginName)
+		}
+
+		log.Printf("consolidate snapshots of virtual server %q", vs.OriginName)
+		if err := consolidateSnapshots(*node, vs.VMXFilePath); err != nil {
+			return fmt.Errorf("consolidate snapshots of virtual server %q: %w", vs.OriginName, err)
+		}
+		return nil
+	default:
+		return errSnapshotsNotSupported(vs.OriginName)
+	}
+}
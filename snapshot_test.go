@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsValidSnapshotMode(t *testing.T) {
+	for mode, want := range map[string]bool{"fail": true, "current": true, "consolidate": true, "": false} {
+		if got := isValidSnapshotMode(mode); got != want {
+			t.Errorf("isValidSnapshotMode(%q) = %t, expected %t", mode, got, want)
+		}
+	}
+}
+
+func TestValidateSnapshots(t *testing.T) {
+	withSnapshot := &Disk{SnapshotChain: []string{"/vmfs/volumes/ds1/db/db-000001.vmdk", "/vmfs/volumes/ds1/db/db.vmdk"}}
+
+	tests := []struct {
+		name    string
+		vs      VirtualServer
+		wantErr bool
+	}{
+		{name: "no snapshots", vs: VirtualServer{PrimaryDisk: &Disk{}}},
+		{name: "consolidate", vs: VirtualServer{PrimaryDisk: withSnapshot, SnapshotMode: SnapshotModeConsolidate}},
+		{name: "current", vs: VirtualServer{PrimaryDisk: withSnapshot, SnapshotMode: SnapshotModeCurrent}},
+		{name: "fail", vs: VirtualServer{PrimaryDisk: withSnapshot, SnapshotMode: SnapshotModeFail}, wantErr: true},
+		{name: "additional disk", vs: VirtualServer{PrimaryDisk: &Disk{}, AdditionalDisks: []Disk{*withSnapshot}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.vs.validateSnapshots()
+			if tt.wantErr && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestDiskImportSourcePath(t *testing.T) {
+	chain := []VMDKDescriptor{
+		{Path: "/vmfs/volumes/ds1/db/db-000001.vmdk", CreateType: VMDKCreateTypeVMFSSparse},
+		{Path: "/vmfs/volumes/ds1/db/db.vmdk", CreateType: VMDKCreateTypeVMFS},
+	}
+
+	current := diskFromVMDKChain(chain, SnapshotModeCurrent)
+	if current.SourcePath != chain[0].Path {
+		t.Errorf("source path is %q in mode %q, expected the top of the chain", current.SourcePath, SnapshotModeCurrent)
+	}
+
+	// Consolidation removes delta disks, so the base disk is recorded.
+	consolidated := diskFromVMDKChain(chain, SnapshotModeConsolidate)
+	if consolidated.SourcePath != chain[1].Path || consolidated.CreateType != VMDKCreateTypeVMFS {
+		t.Errorf("source path is %q %s in mode %q, expected the base disk", consolidated.SourcePath, consolidated.CreateType, SnapshotModeConsolidate)
+	}
+
+	// Snapshot mode may be changed in the plan after creation.
+	if got := consolidated.importSourcePath(SnapshotModeCurrent); got != chain[0].Path {
+		t.Errorf("import source path is %q in mode %q, expected the top of the chain", got, SnapshotModeCurrent)
+	}
+	if got := current.importSourcePath(SnapshotModeConsolidate); got != chain[1].Path {
+		t.Errorf("import source path is %q in mode %q, expected the base disk", got, SnapshotModeConsolidate)
+	}
+}
@@ -8,7 +8,15 @@ import (
 	"time"
 )
 
-func createVirtualServers(settings ImportSettings, importPlanFilePath string, recreate bool) error {
+type createVirtualServersOptions struct {
+	Recreate bool
+	// DryRun validates the plan online and prints create requests without creating anything.
+	DryRun bool
+	// ValidateOnline validates the plan references with SolusVM 2 API before creation.
+	ValidateOnline bool
+}
+
+func createVirtualServers(settings ImportSettings, importPlanFilePath string, opts createVirtualServersOptions) error {
 	plan, err := loadImportPlan(importPlanFilePath)
 	if err != nil {
 		return err
@@ -20,46 +28,51 @@ func createVirtualServers(settings ImportSettings, importPlanFilePath string, re
 		return fmt.Errorf("failed to validate import plan: %v", err)
 	}
 
-	baseURL, err := url.Parse(plan.Settings.APIURL)
+	client, err := newSolusClient(plan.Settings)
 	if err != nil {
-		return fmt.Errorf("parse api url %q: %w", plan.Settings.APIURL, err)
+		return err
 	}
 
-	client, err := solus.NewClient(baseURL, solus.APITokenAuthenticator{Token: plan.Settings.APIToken})
-	if err != nil {
-		return fmt.Errorf("create client: %w", err)
+	if err := resolveOSImageNames(client, &plan.Settings.Defaults); err != nil {
+		return fmt.Errorf("failed to resolve OS images: %w", err)
 	}
 
-	for i, vsPlan := range plan.VirtualServers {
-		if vsPlan.VirtualServerID != 0 && !recreate {
-			continue
-		}
+	printGuestOSReport(plan)
 
-		crID := plan.Settings.Defaults.ComputeResourceID
-		if vsPlan.ComputeResourceID != 0 {
-			crID = vsPlan.ComputeResourceID
+	if opts.DryRun {
+		return dryRunCreateVirtualServers(client, plan, opts.Recreate)
+	}
+
+	if opts.ValidateOnline {
+		if err := plan.ValidateOnline(client, opts.Recreate); err != nil {
+			return fmt.Errorf("failed to validate import plan online: %w", err)
 		}
+	}
 
-		for i, d := range vsPlan.AdditionalDisks {
-			if d.DiskOfferID == 0 {
-				vsPlan.AdditionalDisks[i].DiskOfferID = plan.Settings.Defaults.AdditionalDiskOfferID
+	ipAllocator := newPrimaryIPAllocator(plan.Settings, plan)
+
+	for i, vsPlan := range plan.VirtualServers {
+		if vsPlan.VirtualServerID != 0 && !opts.Recreate {
+			// Disk paths are unknown if the previous run failed right after the virtual server was created.
+			if vsPlan.PrimaryDiskDestinationPath == "" {
+				if err := fillDiskDestinationPaths(client, &plan.VirtualServers[i]); err != nil {
+					return err
+				}
+				if err := saveImportPlan(importPlanFilePath, plan); err != nil {
+					return fmt.Errorf("save import plan: %w", err)
+				}
 			}
+			continue
 		}
 
-		data := solus.VirtualServerCreateRequest{
-			Name:              vsPlan.Hostname,
-			SSHKeys:           append(plan.Settings.Defaults.SSHKeys, vsPlan.SSHKeys...),
-			ProjectID:         plan.Settings.Defaults.ProjectID,
-			LocationID:        plan.Settings.Defaults.LocationID,
-			ComputeResourceID: crID,
-			OSImageVersionID:  plan.Settings.Defaults.GuestOSToOSImageVersionID[vsPlan.GuestOS],
-			CustomPlan:        &vsPlan.CustomPlan,
-			AdditionalIPCount: vsPlan.AdditionalIPv4,
-			PrimaryIP:         vsPlan.PrimaryIP,
-			AdditionalDisks:   diskToAdditionalDiskCreateRequest(vsPlan.AdditionalDisks),
-			MacAddress:        vsPlan.MacAddress,
-			Firmware:          vsPlan.Firmware,
+		// Primary IP is picked from the IP block of the primary interface if it's not set.
+		primaryIP, err := ipAllocator.primaryIP(vsPlan)
+		if err != nil {
+			return err
 		}
+		vsPlan.PrimaryIP = primaryIP
+
+		data := buildVirtualServerCreateRequest(plan.Settings, vsPlan)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
 		vs, err := client.VirtualServers.Create(ctx, data)
@@ -71,28 +84,25 @@ func createVirtualServers(settings ImportSettings, importPlanFilePath string, re
 
 		fmt.Printf("Virtual server %q created as ID %d\n", vsPlan.Hostname, vs.ID)
 
-		ctx, cancel = context.WithTimeout(context.Background(), 35*time.Second)
-		disks, err := client.VirtualServers.Disks(ctx, vs.ID)
-		if err != nil {
-			cancel()
-			return fmt.Errorf("create virtual server %q: %w", vsPlan.Hostname, err)
+		// The virtual server is saved to the plan right away, so it's rolled back even if the next steps fail.
+		created := &plan.VirtualServers[i]
+		created.PrimaryIP = primaryIP
+		created.VirtualServerUUID = vs.UUID
+		created.VirtualServerID = vs.ID
+		created.ImportState = ImportStateCreated
+		created.ImportResumeState = ""
+		created.ImportError = ""
+		created.PrimaryDiskDestinationPath = ""
+		for y := range created.AdditionalDisks {
+			created.AdditionalDisks[y].DestinationPath = ""
 		}
-		cancel()
-
-		plan.VirtualServers[i].VirtualServerUUID = vs.UUID
-		plan.VirtualServers[i].VirtualServerID = vs.ID
 
-		for _, dest := range disks {
-			if dest.IsPrimary {
-				plan.VirtualServers[i].PrimaryDiskDestinationPath = dest.FullPath
-				continue
-			}
+		if err := saveImportPlan(importPlanFilePath, plan); err != nil {
+			return fmt.Errorf("save import plan: %w", err)
+		}
 
-			for y, source := range plan.VirtualServers[i].AdditionalDisks {
-				if dest.Name == source.Name {
-					plan.VirtualServers[i].AdditionalDisks[y].DestinationPath = dest.FullPath
-				}
-			}
+		if err := fillDiskDestinationPaths(client, created); err != nil {
+			return err
 		}
 
 		if err := saveImportPlan(importPlanFilePath, plan); err != nil {
@@ -103,6 +113,87 @@ func createVirtualServers(settings ImportSettings, importPlanFilePath string, re
 	return nil
 }
 
+// fillDiskDestinationPaths sets destination paths of disks of the created virtual server.
+func fillDiskDestinationPaths(client *solus.Client, vs *VirtualServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	defer cancel()
+
+	disks, err := client.VirtualServers.Disks(ctx, vs.VirtualServerID)
+	if err != nil {
+		return fmt.Errorf("get disks of virtual server %q: %w", vs.Hostname, err)
+	}
+
+	for _, dest := range disks {
+		if dest.IsPrimary {
+			vs.PrimaryDiskDestinationPath = dest.FullPath
+			continue
+		}
+
+		for y, source := range vs.AdditionalDisks {
+			if dest.Name == source.Name {
+				vs.AdditionalDisks[y].DestinationPath = dest.FullPath
+			}
+		}
+	}
+
+	return nil
+}
+
+func newSolusClient(settings ImportSettings) (*solus.Client, error) {
+	baseURL, err := url.Parse(settings.APIURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse api url %q: %w", settings.APIURL, err)
+	}
+
+	client, err := solus.NewClient(baseURL, solus.APITokenAuthenticator{Token: settings.APIToken})
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+
+	return client, nil
+}
+
+// buildVirtualServerCreateRequest builds SolusVM 2 create request for the virtual server
+// with defaults from settings.
+func buildVirtualServerCreateRequest(settings ImportSettings, vsPlan VirtualServer) solus.VirtualServerCreateRequest {
+	crID := settings.Defaults.ComputeResourceID
+	if vsPlan.ComputeResourceID != 0 {
+		crID = vsPlan.ComputeResourceID
+	}
+
+	additionalDisks := make([]Disk, len(vsPlan.AdditionalDisks))
+	copy(additionalDisks, vsPlan.AdditionalDisks)
+	for i, d := range additionalDisks {
+		if d.DiskOfferID == 0 {
+			additionalDisks[i].DiskOfferID = settings.Defaults.AdditionalDiskOfferID
+		}
+	}
+
+	sshKeys := make([]int, 0, len(settings.Defaults.SSHKeys)+len(vsPlan.SSHKeys))
+	sshKeys = append(sshKeys, settings.Defaults.SSHKeys...)
+	sshKeys = append(sshKeys, vsPlan.SSHKeys...)
+
+	customPlan := vsPlan.CustomPlan
+
+	// Unresolved guest OS is reported by Validate.
+	guestOS, _ := resolveGuestOS(settings.Defaults, vsPlan)
+
+	return solus.VirtualServerCreateRequest{
+		Name:              vsPlan.Hostname,
+		SSHKeys:           sshKeys,
+		ProjectID:         settings.Defaults.ProjectID,
+		LocationID:        settings.Defaults.LocationID,
+		ComputeResourceID: crID,
+		OSImageVersionID:  guestOS.OSImageVersionID,
+		CustomPlan:        &customPlan,
+		AdditionalIPCount: vsPlan.additionalIPCount(settings),
+		PrimaryIP:         vsPlan.PrimaryIP,
+		AdditionalDisks:   diskToAdditionalDiskCreateRequest(additionalDisks),
+		MacAddress:        vsPlan.MacAddress,
+		Firmware:          vsPlan.Firmware,
+	}
+}
+
 func diskToAdditionalDiskCreateRequest(disks []Disk) []solus.AdditionalDiskCreateRequest {
 	var createRequest []solus.AdditionalDiskCreateRequest
 	for _, disk := range disks {
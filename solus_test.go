@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testSolusAPI is a stub of SolusVM 2 API, responses are set by path like "GET /servers/42/disks".
+// Requests without a response fail with 404 status.
+type testSolusAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]interface{}
+	requests  []string
+}
+
+func newTestSolusAPI(t *testing.T) *testSolusAPI {
+	api := &testSolusAPI{responses: map[string]interface{}{}}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+
+		api.mu.Lock()
+		api.requests = append(api.requests, key)
+		resp, ok := api.responses[key]
+		api.mu.Unlock()
+
+		if !ok {
+			http.Error(w, `{"message": "not stubbed"}`, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": resp})
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *testSolusAPI) respond(key string, data interface{}) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.responses[key] = data
+}
+
+func (api *testSolusAPI) count(key string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	n := 0
+	for _, r := range api.requests {
+		if r == key {
+			n++
+		}
+	}
+	return n
+}
+
+func (api *testSolusAPI) settings() ImportSettings {
+	return ImportSettings{
+		APIURL:   api.URL + "/api/v1/",
+		APIToken: "token",
+		Defaults: Defaults{
+			GuestOSToOSImageVersionID: map[string]int{"ubuntu-64": 3},
+			UserID:                    1,
+			ProjectID:                 1,
+			ComputeResourceID:         1,
+			LocationID:                1,
+			AdditionalDiskOfferID:     2,
+		},
+	}
+}
+
+func testImportPlanFile(t *testing.T, plan ImportPlan) string {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := saveImportPlan(path, plan); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateVirtualServersSavesCreatedServerBeforeDisks(t *testing.T) {
+	api := newTestSolusAPI(t)
+	api.respond("POST /api/v1/servers", map[string]interface{}{"id": 42, "uuid": "5c8a2f4e"})
+
+	vs := testTwoDiskVirtualServer()
+	vs.Hostname = "vm"
+	vs.GuestOS = "ubuntu-64"
+	vs.PrimaryDiskDestinationPath = ""
+	vs.AdditionalDisks[0].Name = "vm_1"
+	vs.AdditionalDisks[0].Size = 20
+	vs.AdditionalDisks[0].DestinationPath = ""
+	path := testImportPlanFile(t, ImportPlan{VirtualServers: []VirtualServer{vs}})
+
+	// Disks of the created virtual server are not available.
+	if err := createVirtualServers(api.settings(), path, createVirtualServersOptions{}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	plan, err := loadImportPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := plan.VirtualServers[0]
+	if created.VirtualServerID != 42 || created.VirtualServerUUID != "5c8a2f4e" || created.ImportState != ImportStateCreated {
+		t.Fatalf("created virtual server is not saved: ID %d, UUID %q, state %q",
+			created.VirtualServerID, created.VirtualServerUUID, created.ImportState)
+	}
+
+	// The next run fills disk paths without creating the virtual server again.
+	api.respond("GET /api/v1/servers/42/disks", []map[string]interface{}{
+		{"id": 1, "is_primary": true, "full_path": "/var/lib/libvirt/images/42"},
+		{"id": 2, "name": "vm_1", "full_path": "/var/lib/libvirt/images/42-1"},
+	})
+	if err := createVirtualServers(api.settings(), path, createVirtualServersOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if n := api.count("POST /api/v1/servers"); n != 1 {
+		t.Errorf("virtual server is created %d times", n)
+	}
+
+	plan, err = loadImportPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created = plan.VirtualServers[0]
+	if created.PrimaryDiskDestinationPath != "/var/lib/libvirt/images/42" || created.AdditionalDisks[0].DestinationPath != "/var/lib/libvirt/images/42-1" {
+		t.Errorf("disk paths are %q and %q", created.PrimaryDiskDestinationPath, created.AdditionalDisks[0].DestinationPath)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solusio/import-vmware/common"
+	"github.com/solusio/import-vmware/ssh"
+	"golang.org/x/term"
+	"os"
+)
+
+const (
+	sourceSSHPasswordEnv      = "SOURCE_SSH_PASSWORD"
+	sourceSSHKeyPassphraseEnv = "SOURCE_SSH_KEY_PASSPHRASE"
+)
+
+// sourceSSHOptions describes how to connect to a source host over SSH.
+type sourceSSHOptions struct {
+	Port int
+	User string
+	// JumpHost is an optional jump host in `[user@]host[:port]` format.
+	JumpHost        string
+	PrivateKeyPath  string
+	KnownHostsPath  string
+	TrustOnFirstUse bool
+	UseAgent        bool
+	// AskPassword prompts for a password if it's not set with SOURCE_SSH_PASSWORD environment variable.
+	AskPassword bool
+
+	password *string
+}
+
+// Password returns a password of the source host or empty string if password authentication is not used.
+// The password is asked once.
+func (o *sourceSSHOptions) Password() (string, error) {
+	if o.password != nil {
+		return *o.password, nil
+	}
+
+	password := os.Getenv(sourceSSHPasswordEnv)
+	if password == "" && o.AskPassword {
+		var err error
+		password, err = promptSecret("Source host SSH password: ")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	o.password = &password
+	return password, nil
+}
+
+// Connect connects to the source host.
+func (o *sourceSSHOptions) Connect(host string, settings ImportSettings) (ssh.NodeConnection, error) {
+	auth, err := o.auth()
+	if err != nil {
+		return ssh.NodeConnection{}, err
+	}
+
+	jump, err := o.jumpHost()
+	if err != nil {
+		return ssh.NodeConnection{}, err
+	}
+
+	return ssh.NewNodeConnection(host, o.Port, o.User, auth, o.hostKey(host, settings), jump)
+}
+
+// Tunnel starts forwarding of a local port to SSH port of the source host through the jump host.
+// It returns nil if the jump host is not used.
+func (o *sourceSSHOptions) Tunnel(host string, settings ImportSettings) (*ssh.Tunnel, error) {
+	jump, err := o.jumpHost()
+	if err != nil || jump == nil {
+		return nil, err
+	}
+
+	auth, err := o.auth()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewTunnel(host, o.Port, *jump, auth, o.hostKey(host, settings))
+}
+
+// sourceHost is a source host the disks are imported from.
+type sourceHost struct {
+	Host string
+	// SSHHost and SSHPort is an address virt-v2v connects to, it differs from Host
+	// when the source host is reached through a tunnel.
+	SSHHost string
+	SSHPort int
+	// Node is a connection to the source host used for power state checks and snapshots consolidation.
+	Node *ssh.NodeConnection
+
+	tunnel *ssh.Tunnel
+}
+
+// ConnectSourceHost connects to the source host to import disks from it.
+func (o *sourceSSHOptions) ConnectSourceHost(host string, settings ImportSettings) (*sourceHost, error) {
+	h := &sourceHost{
+		Host:    host,
+		SSHHost: host,
+		SSHPort: o.Port,
+	}
+
+	node, err := o.Connect(host, settings)
+	if err != nil {
+		return nil, fmt.Errorf("connect to source host %s: %w", host, err)
+	}
+	h.Node = &node
+
+	// virt-v2v can't use a jump host, so it connects to the source host through a local tunnel.
+	tunnel, err := o.Tunnel(host, settings)
+	if err != nil {
+		common.CloseWrapper(node)
+		return nil, fmt.Errorf("create tunnel to source host %s: %w", host, err)
+	}
+	if tunnel != nil {
+		h.tunnel = tunnel
+		h.SSHHost, h.SSHPort = tunnel.Addr().IP.String(), tunnel.Addr().Port
+	}
+
+	return h, nil
+}
+
+// Close closes connection to the source host and the tunnel if any.
+func (h *sourceHost) Close() error {
+	var nodeErr, tunnelErr error
+	if h.Node != nil {
+		nodeErr = h.Node.Close()
+	}
+	if h.tunnel != nil {
+		tunnelErr = h.tunnel.Close()
+	}
+	return errors.Join(nodeErr, tunnelErr)
+}
+
+func (o *sourceSSHOptions) jumpHost() (*ssh.JumpHost, error) {
+	if o.JumpHost == "" {
+		return nil, nil
+	}
+
+	jump, err := ssh.ParseJumpHost(o.JumpHost, o.User)
+	if err != nil {
+		return nil, err
+	}
+	return &jump, nil
+}
+
+func (o *sourceSSHOptions) auth() (ssh.Auth, error) {
+	password, err := o.Password()
+	if err != nil {
+		return ssh.Auth{}, err
+	}
+
+	return ssh.Auth{
+		PrivateKeyPath:       o.PrivateKeyPath,
+		PrivateKeyPassphrase: os.Getenv(sourceSSHKeyPassphraseEnv),
+		PassphrasePrompt: func() (string, error) {
+			return promptSecret(fmt.Sprintf("Passphrase for private key %s: ", o.PrivateKeyPath))
+		},
+		Password: password,
+		UseAgent: o.UseAgent,
+	}, nil
+}
+
+func (o *sourceSSHOptions) hostKey(host string, settings ImportSettings) ssh.HostKeyVerification {
+	return ssh.HostKeyVerification{
+		KnownHostsPath:  o.KnownHostsPath,
+		Fingerprint:     settings.SourceHostKeyFingerprints[host],
+		TrustOnFirstUse: o.TrustOnFirstUse,
+	}
+}
+
+func promptSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("can't prompt for a secret, stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
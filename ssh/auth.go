@@ -0,0 +1,161 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+// Auth describes available authentication methods for a node, all configured methods
+// are tried in order: SSH agent, private key, keyboard-interactive and password.
+type Auth struct {
+	// PrivateKeyPath is a path to a private key file, it's skipped if the file does not
+	// exist and another method is configured.
+	PrivateKeyPath string
+
+	// PrivateKeyPassphrase is a passphrase of an encrypted private key.
+	PrivateKeyPassphrase string
+
+	// PassphrasePrompt is called when the private key is encrypted but passphrase is not set.
+	PassphrasePrompt func() (string, error)
+
+	// Password is used for password and keyboard-interactive authentication.
+	Password string
+
+	// UseAgent enables authentication with SSH agent available by SSH_AUTH_SOCK.
+	UseAgent bool
+}
+
+// credentials reads the private key and fills credentials with auth methods.
+func (a Auth) credentials(c Credentials) (Credentials, error) {
+	c.Password = a.Password
+	c.UseAgent = a.UseAgent && os.Getenv("SSH_AUTH_SOCK") != ""
+
+	if a.PrivateKeyPath != "" {
+		privateKey, err := os.ReadFile(a.PrivateKeyPath)
+		switch {
+		case os.IsNotExist(err) && (c.UseAgent || c.Password != ""):
+			log.Printf("[%s] private key file %q does not exist, skip it", c.Host, a.PrivateKeyPath)
+		case err != nil:
+			return Credentials{}, fmt.Errorf("failed to read private key file %q: %w", a.PrivateKeyPath, err)
+		default:
+			c.Key = string(privateKey)
+		}
+	}
+
+	if c.Key != "" {
+		c.KeyPassphrase = a.PrivateKeyPassphrase
+
+		_, err := ssh.ParseRawPrivateKey([]byte(c.Key))
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) && c.KeyPassphrase == "" {
+			if a.PassphrasePrompt == nil {
+				return Credentials{}, fmt.Errorf("private key %q is encrypted, but passphrase is not provided", a.PrivateKeyPath)
+			}
+
+			passphrase, err := a.PassphrasePrompt()
+			if err != nil {
+				return Credentials{}, fmt.Errorf("read passphrase of private key %q: %w", a.PrivateKeyPath, err)
+			}
+			c.KeyPassphrase = passphrase
+		}
+	}
+
+	if c.Key == "" && c.Password == "" && !c.UseAgent {
+		return Credentials{}, errors.New("no SSH authentication method is available, " +
+			"provide a private key, a password or run SSH agent")
+	}
+
+	return c, nil
+}
+
+// authMethods returns auth methods of c and a connection to SSH agent if it's used, the connection
+// must be closed once authentication is done.
+func authMethods(c Credentials) ([]ssh.AuthMethod, io.Closer, error) {
+	var methods []ssh.AuthMethod
+	var agentConn io.Closer
+	var signerSources []func() ([]ssh.Signer, error)
+
+	if c.UseAgent {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to SSH agent: %w", err)
+		}
+		agentConn = conn
+		signerSources = append(signerSources, agent.NewClient(conn).Signers)
+	}
+
+	if c.Key != "" {
+		signerSources = append(signerSources, func() ([]ssh.Signer, error) {
+			return privateKeySigners(c.Key, c.KeyPassphrase)
+		})
+	}
+
+	if len(signerSources) > 0 {
+		// SSH client never tries a method with the same name twice, so agent keys and the private key
+		// are offered by one public key method.
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			for _, source := range signerSources {
+				s, err := source()
+				if err != nil {
+					return nil, err
+				}
+				signers = append(signers, s...)
+			}
+			return signers, nil
+		}))
+	}
+
+	if c.Password != "" {
+		// ESXi allows password logins with keyboard-interactive method only by default.
+		methods = append(methods,
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range questions {
+					answers[i] = c.Password
+				}
+				return answers, nil
+			}),
+			ssh.Password(c.Password),
+		)
+	}
+
+	return methods, agentConn, nil
+}
+
+// privateKeySigners returns signers of the private key, RSA key signs with SHA-2 algorithms first.
+func privateKeySigners(key, passphrase string) ([]ssh.Signer, error) {
+	signer, err := parsePrivateKey(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []ssh.Signer
+	if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signers = append(signers,
+			&wrappedSigner{
+				Signer:    signer,
+				algorithm: ssh.KeyAlgoRSASHA512,
+			},
+			&wrappedSigner{
+				Signer:    signer,
+				algorithm: ssh.KeyAlgoRSASHA256,
+			},
+		)
+	}
+
+	return append(signers, signer), nil
+}
+
+func parsePrivateKey(key, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey([]byte(key))
+}
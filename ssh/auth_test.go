@@ -0,0 +1,297 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testPrivateKey(t *testing.T, passphrase string) (ed25519.PrivateKey, string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(block))
+}
+
+func writeTestFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthCredentials(t *testing.T) {
+	_, key := testPrivateKey(t, "")
+	_, encryptedKey := testPrivateKey(t, "secret")
+	keyPath := writeTestFile(t, key)
+	encryptedKeyPath := writeTestFile(t, encryptedKey)
+	missingKeyPath := filepath.Join(t.TempDir(), "missing")
+
+	prompt := func() (string, error) { return "prompted", nil }
+
+	tests := []struct {
+		name           string
+		auth           Auth
+		authSock       string
+		wantKey        string
+		wantPassphrase string
+		wantPassword   string
+		wantAgent      bool
+		wantErr        string
+	}{
+		{
+			name:    "private key",
+			auth:    Auth{PrivateKeyPath: keyPath},
+			wantKey: key,
+		},
+		{
+			name:         "all methods",
+			auth:         Auth{PrivateKeyPath: keyPath, Password: "password", UseAgent: true},
+			authSock:     "/run/agent.sock",
+			wantKey:      key,
+			wantPassword: "password",
+			wantAgent:    true,
+		},
+		{
+			name:         "missing private key is skipped with password",
+			auth:         Auth{PrivateKeyPath: missingKeyPath, Password: "password"},
+			wantPassword: "password",
+		},
+		{
+			name:      "missing private key is skipped with agent",
+			auth:      Auth{PrivateKeyPath: missingKeyPath, UseAgent: true},
+			authSock:  "/run/agent.sock",
+			wantAgent: true,
+		},
+		{
+			name:    "missing private key without other methods",
+			auth:    Auth{PrivateKeyPath: missingKeyPath},
+			wantErr: "failed to read private key file",
+		},
+		{
+			name:    "agent without socket",
+			auth:    Auth{UseAgent: true},
+			wantErr: "no SSH authentication method is available",
+		},
+		{
+			name:    "no methods",
+			auth:    Auth{},
+			wantErr: "no SSH authentication method is available",
+		},
+		{
+			name:           "encrypted private key with passphrase",
+			auth:           Auth{PrivateKeyPath: encryptedKeyPath, PrivateKeyPassphrase: "secret", PassphrasePrompt: prompt},
+			wantKey:        encryptedKey,
+			wantPassphrase: "secret",
+		},
+		{
+			name:           "encrypted private key with prompt",
+			auth:           Auth{PrivateKeyPath: encryptedKeyPath, PassphrasePrompt: prompt},
+			wantKey:        encryptedKey,
+			wantPassphrase: "prompted",
+		},
+		{
+			name:    "encrypted private key without passphrase",
+			auth:    Auth{PrivateKeyPath: encryptedKeyPath},
+			wantErr: "is encrypted, but passphrase is not provided",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", tt.authSock)
+
+			c, err := tt.auth.credentials(Credentials{Host: "esxi"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if c.Key != tt.wantKey {
+				t.Errorf("key is %q, expected %q", c.Key, tt.wantKey)
+			}
+			if c.KeyPassphrase != tt.wantPassphrase {
+				t.Errorf("key passphrase is %q, expected %q", c.KeyPassphrase, tt.wantPassphrase)
+			}
+			if c.Password != tt.wantPassword {
+				t.Errorf("password is %q, expected %q", c.Password, tt.wantPassword)
+			}
+			if c.UseAgent != tt.wantAgent {
+				t.Errorf("use agent is %v, expected %v", c.UseAgent, tt.wantAgent)
+			}
+		})
+	}
+}
+
+// authAttempts runs SSH server which rejects every authentication attempt and returns attempts of
+// the client with methods in order.
+func authAttempts(t *testing.T, methods []ssh.AuthMethod, keyNames map[string]string) []string {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var attempts []string
+	attempt := func(a string) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, a)
+	}
+
+	rejected := errors.New("rejected")
+	config := &ssh.ServerConfig{
+		MaxAuthTries: -1,
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			attempt("publickey " + keyNames[string(key.Marshal())])
+			return nil, rejected
+		},
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, _ ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			attempt("keyboard-interactive")
+			return nil, rejected
+		},
+		PasswordCallback: func(_ ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+			attempt("password")
+			return nil, rejected
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _, _, _ = ssh.NewServerConn(conn, config)
+	}()
+
+	_, err = ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("expected authentication error")
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return attempts
+}
+
+func TestAuthMethodsOrder(t *testing.T) {
+	agentKey, _ := testPrivateKey(t, "")
+	fileKey, fileKeyPEM := testPrivateKey(t, "")
+
+	keyNames := map[string]string{}
+	for name, key := range map[string]ed25519.PrivateKey{"agent": agentKey, "file": fileKey} {
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyNames[string(signer.PublicKey().Marshal())] = name
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: agentKey}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+		want        []string
+	}{
+		{
+			name:        "all methods",
+			credentials: Credentials{UseAgent: true, Key: fileKeyPEM, Password: "password"},
+			want:        []string{"publickey agent", "publickey file", "keyboard-interactive", "password"},
+		},
+		{
+			name:        "private key and password",
+			credentials: Credentials{Key: fileKeyPEM, Password: "password"},
+			want:        []string{"publickey file", "keyboard-interactive", "password"},
+		},
+		{
+			name:        "agent only",
+			credentials: Credentials{UseAgent: true},
+			want:        []string{"publickey agent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods, agentConn, err := authMethods(tt.credentials)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if agentConn != nil {
+				defer func() { _ = agentConn.Close() }()
+			}
+
+			if got := authAttempts(t, methods, keyNames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("authentication attempts are %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
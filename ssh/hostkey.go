@@ -0,0 +1,166 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// HostKeyVerification describes how a host key of a remote node is verified.
+type HostKeyVerification struct {
+	// KnownHostsPath is a path to known_hosts file, ~/.ssh/known_hosts is used when empty.
+	KnownHostsPath string `json:"known_hosts_path,omitempty"`
+
+	// Fingerprint is a pinned SHA256 (like `SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8`)
+	// or legacy MD5 fingerprint of the host key. When set, known_hosts file is not used.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// TrustOnFirstUse adds a key of an unknown host to known_hosts file instead of failing.
+	// A changed key of a known host is still an error.
+	TrustOnFirstUse bool `json:"trust_on_first_use,omitempty"`
+}
+
+// knownHostsMu guards known_hosts file from concurrent appends.
+var knownHostsMu sync.Mutex
+
+func hostKeyCallback(v HostKeyVerification) (ssh.HostKeyCallback, error) {
+	if v.Fingerprint != "" {
+		return fingerprintCallback(v.Fingerprint), nil
+	}
+
+	path := v.KnownHostsPath
+	if path == "" {
+		var err error
+		path, err = defaultKnownHostsPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) && v.TrustOnFirstUse {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("create known hosts directory: %w", err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			return nil, fmt.Errorf("create known hosts file %q: %w", path, err)
+		}
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts file %q: %w", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key of %s %s does not match the one in %q, "+
+				"it may be a man-in-the-middle attack: %w", hostname, fingerprint, path, err)
+		}
+
+		if !v.TrustOnFirstUse {
+			return fmt.Errorf("host %s is unknown, its key %s is not found in %q, "+
+				"add it with `ssh-keyscan` or enable trust on first use: %w", hostname, fingerprint, path, err)
+		}
+
+		if err := appendKnownHost(path, hostname, remote, key); err != nil {
+			return err
+		}
+
+		log.Printf("[%s] host key %s is added to %q", hostname, fingerprint, path)
+		return nil
+	}, nil
+}
+
+func fingerprintCallback(expected string) ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		sha256 := ssh.FingerprintSHA256(key)
+		md5 := ssh.FingerprintLegacyMD5(key)
+
+		e := strings.TrimSpace(expected)
+		if e == sha256 || "SHA256:"+e == sha256 || strings.EqualFold(strings.TrimPrefix(e, "MD5:"), md5) {
+			return nil
+		}
+
+		return fmt.Errorf("host key of %s %s does not match pinned fingerprint %s", hostname, sha256, expected)
+	}
+}
+
+func appendKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil {
+		if r := knownhosts.Normalize(remote.String()); r != addresses[0] {
+			addresses = append(addresses, r)
+		}
+	}
+
+	return appendKnownHostsLine(path, knownhosts.Line(addresses, key))
+}
+
+func appendKnownHostsLine(path, line string) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open known hosts file %q: %w", path, err)
+	}
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write known hosts file %q: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// removeKnownHostsLine removes every occurrence of the line from known_hosts file.
+func removeKnownHostsLine(path, line string) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read known hosts file %q: %w", path, err)
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if strings.TrimRight(l, "\n") != line {
+			kept = append(kept, l)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "")), 0600); err != nil {
+		return fmt.Errorf("write known hosts file %q: %w", path, err)
+	}
+	return nil
+}
+
+// defaultKnownHostsPath returns ~/.ssh/known_hosts of the current user.
+func defaultKnownHostsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
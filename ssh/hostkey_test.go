@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemoveKnownHostsLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	existing := "esxi.example.tld ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExisting\n"
+	if err := os.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	line := "[127.0.0.1]:40123 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAITunnel"
+	if err := appendKnownHostsLine(path, line); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := removeKnownHostsLine(path, line); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != existing {
+		t.Errorf("known hosts file is %q, expected %q", b, existing)
+	}
+}
+
+func TestTunnelSeedKnownHostsUsesConfiguredPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	privateKey, _ := testPrivateKey(t, "")
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := signer.PublicKey()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	tunnel := &Tunnel{listener: listener}
+	if err := tunnel.seedKnownHosts(HostKeyVerification{KnownHostsPath: path}, key); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), knownhosts.Normalize(listener.Addr().String())+" ") {
+		t.Errorf("known hosts file does not contain tunnel address: %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".ssh", "known_hosts")); !os.IsNotExist(err) {
+		t.Errorf("default known hosts file is changed: %v", err)
+	}
+
+	if err := removeKnownHostsLine(tunnel.knownHostsPath, tunnel.knownHostsLine); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b, _ := os.ReadFile(path); len(b) != 0 {
+		t.Errorf("tunnel entry is not removed: %q", b)
+	}
+}
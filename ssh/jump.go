@@ -0,0 +1,278 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solusio/import-vmware/common"
+	"github.com/solusio/import-vmware/goroutine"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPort = 22
+
+// JumpHost is a bastion host used to reach a node, like OpenSSH ProxyJump.
+type JumpHost struct {
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Login string `json:"login"`
+}
+
+// ParseJumpHost parses jump host in `[user@]host[:port]` format, login is defaultLogin if not specified.
+func ParseJumpHost(s, defaultLogin string) (JumpHost, error) {
+	j := JumpHost{
+		Port:  defaultPort,
+		Login: defaultLogin,
+	}
+
+	if login, host, ok := strings.Cut(s, "@"); ok {
+		j.Login = login
+		s = host
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// There is no port.
+		host = strings.Trim(s, "[]")
+	} else {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return JumpHost{}, fmt.Errorf("invalid jump host port %q: %w", port, err)
+		}
+		j.Port = p
+	}
+
+	if host == "" || j.Login == "" {
+		return JumpHost{}, fmt.Errorf("invalid jump host %q, [user@]host[:port] expected", s)
+	}
+	j.Host = host
+
+	return j, nil
+}
+
+// jumpCredentials returns credentials of the jump host of c. The same authentication methods
+// are used, but the jump host key is verified with known hosts only since pinned fingerprint
+// belongs to the node.
+func jumpCredentials(c Credentials) Credentials {
+	jc := c
+	jc.Host = c.Jump.Host
+	jc.Port = c.Jump.Port
+	jc.Login = c.Jump.Login
+	jc.Jump = nil
+	jc.HostKey.Fingerprint = ""
+	return jc
+}
+
+// dialThroughJump connects to the node of c through its jump host.
+func dialThroughJump(c Credentials, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	jumpClient, err := dialSSH(jumpCredentials(c))
+	if err != nil {
+		return nil, fmt.Errorf("dial jump host %s: %w", c.Jump.Host, err)
+	}
+
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	conn, err := jumpClient.Dial("tcp", addr)
+	if err != nil {
+		common.CloseWrapper(jumpClient)
+		return nil, fmt.Errorf("dial %s through jump host %s: %w", addr, c.Jump.Host, err)
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		common.CloseWrapper(conn, jumpClient)
+		return nil, err
+	}
+
+	client := ssh.NewClient(clientConn, chans, reqs)
+	// The jump host connection is only used by the client, so it's closed with the client.
+	go func() {
+		_ = client.Wait()
+		common.CloseWrapper(jumpClient)
+	}()
+
+	return client, nil
+}
+
+// Tunnel forwards connections from a local port to a node port through the jump host.
+// It allows to use external tools like virt-v2v which can't use a jump host by themselves.
+// The verified host key of the node is added to the known hosts file of the host key verification
+// (~/.ssh/known_hosts by default) for the local address of the tunnel while it's open,
+// so the tools can verify the node connecting to 127.0.0.1.
+type Tunnel struct {
+	listener   net.Listener
+	jumpClient *ssh.Client
+	wg         sync.WaitGroup
+
+	knownHostsPath string
+	knownHostsLine string
+}
+
+// errHostKeyVerified aborts the handshake once the host key is verified, authentication is not needed.
+var errHostKeyVerified = errors.New("host key is verified")
+
+// verifyHostKey returns the host key of target reached through the jump client if it passes verification.
+func verifyHostKey(jumpClient *ssh.Client, target string, v HostKeyVerification) (ssh.PublicKey, error) {
+	callback, err := hostKeyCallback(v)
+	if err != nil {
+		return nil, fmt.Errorf("host key verification: %w", err)
+	}
+
+	conn, err := jumpClient.Dial("tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", target, err)
+	}
+	defer common.CloseWrapper(conn)
+
+	var key ssh.PublicKey
+	_, _, _, err = ssh.NewClientConn(conn, target, &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			if err := callback(hostname, remote, k); err != nil {
+				return err
+			}
+			key = k
+			return errHostKeyVerified
+		},
+	})
+	if key == nil {
+		return nil, fmt.Errorf("verify host key of %s: %w", target, err)
+	}
+
+	return key, nil
+}
+
+// seedKnownHosts adds the host key for the local address of the tunnel to the known hosts file
+// of v, ~/.ssh/known_hosts is used when it's not set.
+func (t *Tunnel) seedKnownHosts(v HostKeyVerification, key ssh.PublicKey) error {
+	path := v.KnownHostsPath
+	if path == "" {
+		var err error
+		path, err = defaultKnownHostsPath()
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create known hosts directory: %w", err)
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(t.listener.Addr().String())}, key)
+	if err := appendKnownHostsLine(path, line); err != nil {
+		return err
+	}
+
+	t.knownHostsPath = path
+	t.knownHostsLine = line
+	return nil
+}
+
+// NewTunnel starts forwarding from a random local port to host:port through the jump host.
+func NewTunnel(host string, port int, jump JumpHost, auth Auth, hostKey HostKeyVerification) (*Tunnel, error) {
+	c, err := auth.credentials(Credentials{
+		Host:    host,
+		Port:    port,
+		Jump:    &jump,
+		HostKey: hostKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	jumpClient, err := dialSSH(jumpCredentials(c))
+	if err != nil {
+		return nil, fmt.Errorf("dial jump host %s: %w", jump.Host, err)
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	key, err := verifyHostKey(jumpClient, target, c.HostKey)
+	if err != nil {
+		common.CloseWrapper(jumpClient)
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		common.CloseWrapper(jumpClient)
+		return nil, fmt.Errorf("listen local port: %w", err)
+	}
+
+	t := &Tunnel{
+		listener:   listener,
+		jumpClient: jumpClient,
+	}
+
+	if err := t.seedKnownHosts(c.HostKey, key); err != nil {
+		common.CloseWrapper(listener, jumpClient)
+		return nil, fmt.Errorf("add host key of tunnel %s: %w", listener.Addr(), err)
+	}
+
+	log.Printf("[%s] tunnel %s -> %s is started", jump.Host, listener.Addr(), target)
+
+	t.wg.Add(1)
+	goroutine.Run(func() {
+		defer t.wg.Done()
+		t.serve(target)
+	})
+
+	return t, nil
+}
+
+// Addr returns local address of the tunnel like 127.0.0.1:40123.
+func (t *Tunnel) Addr() *net.TCPAddr {
+	return t.listener.Addr().(*net.TCPAddr)
+}
+
+func (t *Tunnel) serve(target string) {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("tunnel to %s: accept: %s", target, err)
+			}
+			return
+		}
+
+		goroutine.Run(func() {
+			defer common.CloseWrapper(local)
+
+			remote, err := t.jumpClient.Dial("tcp", target)
+			if err != nil {
+				log.Printf("tunnel to %s: dial: %s", target, err)
+				return
+			}
+			defer common.CloseWrapper(remote)
+
+			done := make(chan struct{}, 2)
+			goroutine.Run(func() {
+				_, _ = io.Copy(remote, local)
+				done <- struct{}{}
+			})
+			goroutine.Run(func() {
+				_, _ = io.Copy(local, remote)
+				done <- struct{}{}
+			})
+			<-done
+		})
+	}
+}
+
+func (t *Tunnel) Close() error {
+	err := t.listener.Close()
+	t.wg.Wait()
+	if cErr := t.jumpClient.Close(); err == nil {
+		err = cErr
+	}
+	if t.knownHostsLine != "" {
+		if rErr := removeKnownHostsLine(t.knownHostsPath, t.knownHostsLine); err == nil {
+			err = rErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package ssh
+
+import (
+	"regexp"
+	"strings"
+)
+
+// safeShellArgRegexp matches an argument which doesn't need quoting.
+var safeShellArgRegexp = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
+
+// QuoteShellArg quotes s for a POSIX shell like ESXi busybox ash, so it's passed as a single argument
+// as is. Spaces, quotes, parentheses, `$` and unicode characters are kept literally, e.g.
+// `/vmfs/volumes/datastore1/test vm (1)/it's.vmx` becomes `'/vmfs/volumes/datastore1/test vm (1)/it'"'"'s.vmx'`.
+func QuoteShellArg(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if safeShellArgRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// ShellCommand returns a shell command line of the command with quoted arguments.
+func ShellCommand(name string, args ...string) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, QuoteShellArg(name))
+	for _, arg := range args {
+		parts = append(parts, QuoteShellArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestQuoteShellArg(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{name: "empty", arg: "", want: "''"},
+		{name: "safe", arg: "/vmfs/volumes/datastore1/vm/vm.vmx", want: "/vmfs/volumes/datastore1/vm/vm.vmx"},
+		{name: "spaces", arg: "/vmfs/volumes/datastore1/test vm/test vm.vmx", want: "'/vmfs/volumes/datastore1/test vm/test vm.vmx'"},
+		{name: "single quote", arg: "it's.vmx", want: `'it'"'"'s.vmx'`},
+		{name: "double quote", arg: `say "hi"`, want: `'say "hi"'`},
+		{name: "parentheses", arg: "test vm (1)", want: "'test vm (1)'"},
+		{name: "dollar and backtick", arg: "$HOME`id`", want: "'$HOME`id`'"},
+		{name: "unicode", arg: "виртуалка", want: "'виртуалка'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteShellArg(tt.arg); got != tt.want {
+				t.Errorf("QuoteShellArg(%q) = %s, expected %s", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	got := ShellCommand("vim-cmd", "vmsvc/getallvms", "test vm (1)", "it's")
+	want := `vim-cmd vmsvc/getallvms 'test vm (1)' 'it'"'"'s'`
+	if got != want {
+		t.Errorf("ShellCommand() = %s, expected %s", got, want)
+	}
+}
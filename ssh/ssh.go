@@ -10,16 +10,24 @@ import (
 	"github.com/solusio/import-vmware/common"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"os"
 	"path"
+	"strconv"
 	"time"
 )
 
 type Credentials struct {
-	Host  string `json:"host"`
-	Port  int    `json:"port"`
-	Login string `json:"login"`
-	Key   string `json:"key"`
+	Host          string              `json:"host"`
+	Port          int                 `json:"port"`
+	Login         string              `json:"login"`
+	Key           string              `json:"key"`
+	KeyPassphrase string              `json:"-"`
+	Password      string              `json:"-"`
+	UseAgent      bool                `json:"use_agent"`
+	HostKey       HostKeyVerification `json:"host_key"`
+	// Jump is a jump host to connect through, the node is connected directly if it's nil.
+	Jump *JumpHost `json:"jump,omitempty"`
 }
 
 type Connection struct {
@@ -47,13 +55,14 @@ func NewConnection(c Credentials) (*Connection, error) {
 }
 
 func (c *Connection) connect() (*ssh.Client, *sftp.Client, error) {
-	sshClient, err := dialSSH(c.credentials.Host, c.credentials.Port, c.credentials.Login, c.credentials.Key)
+	sshClient, err := dialSSH(c.credentials)
 	if err != nil {
 		return nil, nil, fmt.Errorf("dial SSH: %w", err)
 	}
 
 	sftpClient, err := sftp.NewClient(sshClient, c.getSFTPOpts()...)
 	if err != nil {
+		common.CloseWrapper(sshClient)
 		return nil, nil, fmt.Errorf("establish SFTP connection: %w", err)
 	}
 	return sshClient, sftpClient, nil
@@ -69,13 +78,16 @@ func (*Connection) getSFTPOpts() []sftp.ClientOption {
 	}
 }
 
+// Close closes the SFTP connection and the SSH client under it.
 func (c *Connection) Close() error {
+	var sftpErr, sshErr error
 	if c.sftpClient != nil {
-		if err := c.sftpClient.Close(); err != nil {
-			return err
-		}
+		sftpErr = c.sftpClient.Close()
 	}
-	return nil
+	if c.sshClient != nil {
+		sshErr = c.sshClient.Close()
+	}
+	return errors.Join(sftpErr, sshErr)
 }
 
 func (c *Connection) Exec(cmd string) ([]byte, error) {
@@ -178,6 +190,14 @@ func (c *Connection) makeSureDirectoryExists(p string) error {
 	return nil
 }
 
+func (c *Connection) Stat(_ context.Context, p string) (os.FileInfo, error) {
+	return c.sftpClient.Stat(p)
+}
+
+func (c *Connection) ReadLink(_ context.Context, p string) (string, error) {
+	return c.sftpClient.ReadLink(p)
+}
+
 func (c *Connection) IsExists(_ context.Context, path string) (bool, error) {
 	_, err := c.sftpClient.Stat(path)
 
@@ -254,6 +274,8 @@ func (c *Connection) sftpDownload(ctx context.Context, srcPath string) (io.ReadC
 	}()
 
 	go func() {
+		defer common.CloseWrapper(fp)
+
 		_, err := fp.WriteTo(tx)
 		if ctx.Err() != nil {
 			err = ctx.Err()
@@ -264,41 +286,33 @@ func (c *Connection) sftpDownload(ctx context.Context, srcPath string) (io.ReadC
 	return rx, nil
 }
 
-func dialSSH(host string, port int, login, key string) (*ssh.Client, error) {
+func dialSSH(c Credentials) (*ssh.Client, error) {
 	const timeout = 5 * time.Second
 
+	hostKeyCallback, err := hostKeyCallback(c.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("host key verification: %w", err)
+	}
+
+	auth, agentConn, err := authMethods(c)
+	if err != nil {
+		return nil, err
+	}
+	// SSH agent is used on authentication only, which is done by the time the client is dialed.
+	defer common.CloseWrapper(agentConn)
+
 	cfg := &ssh.ClientConfig{
-		User: login,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(func() (signers []ssh.Signer, err error) {
-				signer, err := ssh.ParsePrivateKey([]byte(key))
-				if err != nil {
-					return nil, err
-				}
-
-				if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
-					signers = append(signers,
-						&wrappedSigner{
-							Signer:    signer,
-							algorithm: ssh.KeyAlgoRSASHA512,
-						},
-						&wrappedSigner{
-							Signer:    signer,
-							algorithm: ssh.KeyAlgoRSASHA256,
-						},
-					)
-				}
-
-				signers = append(signers, signer)
-
-				return signers, nil
-			}),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // Because we can't relay on that.
+		User:            c.Login,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         timeout,
 	}
 
-	return ssh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), cfg)
+	if c.Jump != nil {
+		return dialThroughJump(c, cfg)
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), cfg)
 }
 
 // wrappedSigner wraps a signer and overrides its public key type with the provided
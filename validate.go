@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/solusio/import-vmware/common"
+	"github.com/solusio/solus-go-sdk"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidateOnline fetches every entity referenced by the plan with SolusVM 2 API and checks
+// they fit each other. Virtual servers which are already created are skipped unless recreate is set.
+// All found problems are returned together.
+func (i *ImportPlan) ValidateOnline(client *solus.Client, recreate bool) error {
+	settings := i.Settings
+	defaults := settings.Defaults
+
+	var v onlineValidator
+
+	// requiredDisks contains disks of virtual servers to be created on every compute resource.
+	requiredDisks := map[int][]diskRequirement{defaults.ComputeResourceID: nil}
+	osImageVersions := map[int]bool{}
+	offers := map[int]bool{}
+	sshKeys := map[int]bool{}
+	// ipBlocks contains compute resources and primary IPs every IP block is used with.
+	ipBlocks := map[int]*ipBlockUsage{}
+	for _, id := range defaults.SSHKeys {
+		sshKeys[id] = true
+	}
+
+	for _, vs := range i.VirtualServers {
+		if vs.VirtualServerID != 0 && !recreate {
+			continue
+		}
+
+		req := buildVirtualServerCreateRequest(settings, vs)
+		requiredDisks[req.ComputeResourceID] = append(requiredDisks[req.ComputeResourceID], diskRequirement{
+			Name:        vs.Hostname,
+			StorageType: planStorageType(vs.CustomPlan),
+			SizeGiB:     vs.CustomPlan.Params.Disk,
+		})
+		osImageVersions[req.OSImageVersionID] = true
+		for _, d := range req.AdditionalDisks {
+			offers[d.OfferID] = true
+			requiredDisks[req.ComputeResourceID] = append(requiredDisks[req.ComputeResourceID], diskRequirement{
+				Name:    vs.Hostname + " " + d.Name,
+				OfferID: d.OfferID,
+				SizeGiB: d.Size,
+			})
+		}
+		for _, id := range req.SSHKeys {
+			sshKeys[id] = true
+		}
+
+		mappings, err := vs.networkMappings(settings)
+		if err != nil {
+			v.errs = append(v.errs, err)
+			continue
+		}
+		for n, m := range mappings {
+			usage, ok := ipBlocks[m.IPBlockID]
+			if !ok {
+				usage = &ipBlockUsage{computeResources: map[int]bool{}}
+				ipBlocks[m.IPBlockID] = usage
+			}
+			usage.computeResources[req.ComputeResourceID] = true
+			if n == 0 && vs.PrimaryIP != nil {
+				usage.primaryIPs = append(usage.primaryIPs, *vs.PrimaryIP)
+			}
+			if n > 0 {
+				usage.additionalInterfaces = append(usage.additionalInterfaces, vs.Hostname+" "+vs.NetworkInterfaces[n].Device)
+			}
+		}
+	}
+
+	v.lookup("user", defaults.UserID, func(ctx context.Context) (string, error) {
+		var u solus.User
+		if err := solusAPIGet(ctx, settings, fmt.Sprintf("users/%d", defaults.UserID), &u); err != nil {
+			return "", err
+		}
+		return u.Email, nil
+	})
+
+	v.lookup("project", defaults.ProjectID, func(ctx context.Context) (string, error) {
+		p, err := client.Projects.Get(ctx, defaults.ProjectID)
+		if err != nil {
+			return "", err
+		}
+		if p.Owner.ID != 0 && p.Owner.ID != defaults.UserID {
+			return "", fmt.Errorf("project %q is owned by user %d, but user %d is set in settings", p.Name, p.Owner.ID, defaults.UserID)
+		}
+		return p.Name, nil
+	})
+
+	v.lookup("location", defaults.LocationID, func(ctx context.Context) (string, error) {
+		l, err := client.Locations.Get(ctx, defaults.LocationID)
+		return l.Name, err
+	})
+
+	// Storage type of an additional disk is set by its offer.
+	offerStorageTypes := map[int]solus.StorageTypeName{}
+	for _, id := range sortedIDs(offers) {
+		v.lookup("disk offer", id, func(ctx context.Context) (string, error) {
+			var o diskOffer
+			if err := solusAPIGet(ctx, settings, fmt.Sprintf("offers/%d", id), &o); err != nil {
+				return "", err
+			}
+			if o.Type != solus.OfferTypeAdditionalDisk {
+				return "", fmt.Errorf("offer %q type is %q, but %q expected", o.Name, o.Type, solus.OfferTypeAdditionalDisk)
+			}
+			if len(o.AvailableLocations) > 0 && !locationsContain(o.AvailableLocations, defaults.LocationID) {
+				return "", fmt.Errorf("offer %q is not available in location %d", o.Name, defaults.LocationID)
+			}
+			offerStorageTypes[id] = o.storageType()
+			return o.Name, nil
+		})
+	}
+
+	for _, id := range sortedIDs(requiredDisks) {
+		v.lookup("compute resource", id, func(ctx context.Context) (string, error) {
+			cr, err := client.ComputeResources.Get(ctx, id)
+			if err != nil {
+				return "", err
+			}
+
+			if !locationsContain(cr.Locations, defaults.LocationID) {
+				return "", fmt.Errorf("compute resource %q does not belong to location %d", cr.Name, defaults.LocationID)
+			}
+
+			if cr.Status != solus.ComputeResourceStatusActive {
+				return "", fmt.Errorf("compute resource %q status is %q", cr.Name, cr.Status)
+			}
+
+			storages, err := client.ComputeResources.StorageList(ctx, id)
+			if err != nil {
+				return "", fmt.Errorf("list storages of compute resource %q: %w", cr.Name, err)
+			}
+
+			disks := make([]diskRequirement, 0, len(requiredDisks[id]))
+			for _, d := range requiredDisks[id] {
+				if d.OfferID != 0 {
+					t, ok := offerStorageTypes[d.OfferID]
+					if !ok {
+						// Failed offer lookup is already reported.
+						continue
+					}
+					d.StorageType = t
+				}
+				disks = append(disks, d)
+			}
+
+			if err := checkStorageSpace(storages, disks); err != nil {
+				return "", fmt.Errorf("compute resource %q: %w", cr.Name, err)
+			}
+
+			return cr.Name, nil
+		})
+	}
+
+	for _, id := range sortedIDs(osImageVersions) {
+		v.lookup("OS image version", id, func(ctx context.Context) (string, error) {
+			ver, err := client.OsImageVersions.Get(ctx, id)
+			if err != nil {
+				return "", err
+			}
+			if ver.VirtualizationType != "" && ver.VirtualizationType != solus.VirtualizationTypeKVM {
+				return "", fmt.Errorf("OS image version %q virtualization type is %q, but %q expected",
+					ver.Version, ver.VirtualizationType, solus.VirtualizationTypeKVM)
+			}
+			return ver.Version, nil
+		})
+	}
+
+	for _, id := range sortedIDs(sshKeys) {
+		v.lookup("SSH key", id, func(ctx context.Context) (string, error) {
+			k, err := client.SSHKeys.Get(ctx, id)
+			return k.Name, err
+		})
+	}
+
+	for _, id := range sortedIDs(ipBlocks) {
+		v.lookup("IP block", id, func(ctx context.Context) (string, error) {
+			var b ipBlockInfo
+			if err := solusAPIGet(ctx, settings, fmt.Sprintf("ip_blocks/%d", id), &b); err != nil {
+				return "", err
+			}
+			for _, crID := range sortedIDs(ipBlocks[id].computeResources) {
+				if !b.hasComputeResource(crID) {
+					return "", fmt.Errorf("IP block %q is not attached to compute resource %d", b.Name, crID)
+				}
+			}
+			// SolusVM 2 allocates additional addresses from IPv4 range IP blocks of the compute resource only,
+			// an interface mapped to another IP block would get an address of a block it's not mapped to.
+			if ifaces := ipBlocks[id].additionalInterfaces; len(ifaces) > 0 &&
+				(b.Type != solus.IPv4 || b.ListType != solus.IpBlockListTypeRange) {
+				return "", fmt.Errorf("IP block %q is mapped to network interfaces %s, but additional IPv4 addresses are allocated from IPv4 range IP blocks only",
+					b.Name, strings.Join(ifaces, ", "))
+			}
+			for _, ip := range ipBlocks[id].primaryIPs {
+				if !b.contains(ip) {
+					return "", fmt.Errorf("primary IP %s is out of IP block %q range %s-%s", ip, b.Name, b.From, b.To)
+				}
+			}
+			return b.Name, nil
+		})
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// ipBlockUsage is how an IP block is used by virtual servers of the plan.
+type ipBlockUsage struct {
+	computeResources map[int]bool
+	primaryIPs       []string
+	// additionalInterfaces are interfaces after the primary one, they get additional IPv4 addresses.
+	additionalInterfaces []string
+}
+
+type onlineValidator struct {
+	errs []error
+}
+
+// lookup calls get and collects an error if any.
+func (v *onlineValidator) lookup(kind string, id int, get func(ctx context.Context) (string, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Second)
+	defer cancel()
+
+	name, err := get(ctx)
+	if err != nil {
+		v.errs = append(v.errs, fmt.Errorf("%s %d: %w", kind, id, err))
+		return
+	}
+	log.Printf("resolved %s %d: %s", kind, id, name)
+}
+
+func locationsContain(locations []solus.Location, id int) bool {
+	for _, l := range locations {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// diskRequirement is a disk of a virtual server to be created on a compute resource.
+type diskRequirement struct {
+	Name        string
+	StorageType solus.StorageTypeName
+	// OfferID is set for an additional disk, which storage type is set by the offer.
+	OfferID int
+	SizeGiB int
+}
+
+// planStorageType returns storage type of the primary disk, file based one if it's not set.
+func planStorageType(plan solus.Plan) solus.StorageTypeName {
+	if plan.StorageType == "" {
+		return solus.StorageTypeNameFB
+	}
+	return solus.StorageTypeName(plan.StorageType)
+}
+
+// diskOffer is an additional disk offer with its storage type, which solus-go-sdk doesn't decode.
+type diskOffer struct {
+	solus.Offer
+	StorageType *offerStorageType `json:"storage_type"`
+}
+
+// storageType returns storage type of disks of the offer, file based one if it's not set.
+func (o diskOffer) storageType() solus.StorageTypeName {
+	if o.StorageType == nil || o.StorageType.Name == "" {
+		return solus.StorageTypeNameFB
+	}
+	return o.StorageType.Name
+}
+
+// offerStorageType is decoded both from a storage type name and a storage type object.
+type offerStorageType solus.StorageType
+
+func (t *offerStorageType) UnmarshalJSON(data []byte) error {
+	var name solus.StorageTypeName
+	if err := json.Unmarshal(data, &name); err == nil {
+		t.Name = name
+		return nil
+	}
+
+	var st solus.StorageType
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	*t = offerStorageType(st)
+	return nil
+}
+
+// checkStorageSpace checks every disk fits a storage of its type and all disks of a type fit
+// the total free space of storages of the type.
+func checkStorageSpace(storages []solus.Storage, disks []diskRequirement) error {
+	type typeSpace struct {
+		maxFree, totalFree float64
+		required           int
+		found              bool
+	}
+	space := map[solus.StorageTypeName]*typeSpace{}
+	for _, d := range disks {
+		if _, ok := space[d.StorageType]; !ok {
+			space[d.StorageType] = &typeSpace{}
+		}
+	}
+	for _, s := range storages {
+		ts, ok := space[s.Type.Name]
+		if !ok {
+			continue
+		}
+		ts.found = true
+		ts.totalFree += s.FreeSpace
+		if s.FreeSpace > ts.maxFree {
+			ts.maxFree = s.FreeSpace
+		}
+	}
+
+	var errs []error
+	for _, d := range disks {
+		ts := space[d.StorageType]
+		ts.required += d.SizeGiB
+		if !ts.found {
+			continue
+		}
+		if float64(d.SizeGiB) > ts.maxFree {
+			errs = append(errs, fmt.Errorf("disk of %s requires %d GiB, but the biggest free space of %q storages is %.0f GiB",
+				d.Name, d.SizeGiB, d.StorageType, ts.maxFree))
+		}
+	}
+
+	types := make([]string, 0, len(space))
+	for t := range space {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		ts := space[solus.StorageTypeName(t)]
+		if !ts.found {
+			errs = append(errs, fmt.Errorf("no %q storage for %d GiB of disks", t, ts.required))
+			continue
+		}
+		if float64(ts.required) > ts.totalFree {
+			errs = append(errs, fmt.Errorf("%q storages have %.0f GiB of free space, but %d GiB is required",
+				t, ts.totalFree, ts.required))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func sortedIDs[V any](ids map[int]V) []int {
+	result := make([]int, 0, len(ids))
+	for id := range ids {
+		if id == 0 {
+			continue
+		}
+		result = append(result, id)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// solusAPIGet gets an entity from SolusVM 2 API by path relative to API URL.
+// It's used for endpoints which are not supported by solus-go-sdk.
+func solusAPIGet(ctx context.Context, settings ImportSettings, path string, v interface{}) error {
+	u, err := url.JoinPath(settings.APIURL, path)
+	if err != nil {
+		return fmt.Errorf("build url for %q: %w", path, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+settings.APIToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", u, err)
+	}
+	defer common.CloseWrapper(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+
+	data := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("decode response of GET %s: %w", u, err)
+	}
+
+	return nil
+}
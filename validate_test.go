@@ -0,0 +1,198 @@
+package main
+
+import (
+	"github.com/solusio/solus-go-sdk"
+	"strings"
+	"testing"
+)
+
+func TestCheckStorageSpace(t *testing.T) {
+	storages := []solus.Storage{
+		{ID: 1, Type: solus.StorageType{Name: solus.StorageTypeNameFB}, FreeSpace: 100},
+		{ID: 2, Type: solus.StorageType{Name: solus.StorageTypeNameFB}, FreeSpace: 50},
+		{ID: 3, Type: solus.StorageType{Name: solus.StorageTypeNameThinLVM}, FreeSpace: 30},
+	}
+
+	tests := []struct {
+		name    string
+		disks   []diskRequirement
+		wantErr string
+	}{
+		{
+			name: "fit storages of every type",
+			disks: []diskRequirement{
+				{Name: "vm1", StorageType: solus.StorageTypeNameFB, SizeGiB: 90},
+				{Name: "vm2", StorageType: solus.StorageTypeNameFB, SizeGiB: 50},
+				{Name: "vm2 data", StorageType: solus.StorageTypeNameThinLVM, SizeGiB: 30},
+			},
+		},
+		{
+			name: "disk bigger than any storage",
+			disks: []diskRequirement{
+				{Name: "vm1", StorageType: solus.StorageTypeNameFB, SizeGiB: 120},
+			},
+			wantErr: `disk of vm1 requires 120 GiB, but the biggest free space of "fb" storages is 100 GiB`,
+		},
+		{
+			name: "disks bigger than all storages of the type",
+			disks: []diskRequirement{
+				{Name: "vm1", StorageType: solus.StorageTypeNameFB, SizeGiB: 100},
+				{Name: "vm2", StorageType: solus.StorageTypeNameFB, SizeGiB: 60},
+			},
+			wantErr: `"fb" storages have 150 GiB of free space, but 160 GiB is required`,
+		},
+		{
+			name: "free space of other type is not counted",
+			disks: []diskRequirement{
+				{Name: "vm1 data", StorageType: solus.StorageTypeNameThinLVM, SizeGiB: 40},
+			},
+			wantErr: `disk of vm1 data requires 40 GiB, but the biggest free space of "thinlvm" storages is 30 GiB`,
+		},
+		{
+			name: "no storage of the type",
+			disks: []diskRequirement{
+				{Name: "vm1 data", StorageType: solus.StorageTypeNameLVM, SizeGiB: 10},
+			},
+			wantErr: `no "lvm" storage for 10 GiB of disks`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStorageSpace(storages, tt.disks)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// newValidateOnlineTestAPI returns SolusVM 2 API stub with entities referenced by testValidateOnlinePlan.
+func newValidateOnlineTestAPI(t *testing.T, offerStorageType interface{}, storages []solus.Storage) *testSolusAPI {
+	api := newTestSolusAPI(t)
+	api.respond("GET /api/v1/users/1", map[string]interface{}{"id": 1, "email": "admin@example.com"})
+	api.respond("GET /api/v1/projects/1", map[string]interface{}{"id": 1, "name": "default", "owner": map[string]interface{}{"id": 1}})
+	api.respond("GET /api/v1/locations/1", map[string]interface{}{"id": 1, "name": "location"})
+	api.respond("GET /api/v1/compute_resources/1", map[string]interface{}{
+		"id":        1,
+		"name":      "cr",
+		"status":    "active",
+		"locations": []map[string]interface{}{{"id": 1}},
+	})
+	api.respond("GET /api/v1/compute_resources/1/storages", storages)
+	api.respond("GET /api/v1/os_image_versions/3", map[string]interface{}{"id": 3, "version": "22.04", "virtualization_type": "kvm"})
+	api.respond("GET /api/v1/offers/2", map[string]interface{}{
+		"id":                  2,
+		"name":                "disk",
+		"type":                "additional_disk",
+		"available_locations": []map[string]interface{}{{"id": 1}},
+		"storage_type":        offerStorageType,
+	})
+	return api
+}
+
+func testValidateOnlinePlan(settings ImportSettings) *ImportPlan {
+	vs := testTwoDiskVirtualServer()
+	vs.Hostname = "vm"
+	vs.GuestOS = "ubuntu-64"
+	vs.CustomPlan.StorageType = "fb"
+	vs.CustomPlan.Params.Disk = 10
+	vs.AdditionalDisks[0].Name = "vm_1"
+	vs.AdditionalDisks[0].Size = 20
+	return &ImportPlan{Settings: settings, VirtualServers: []VirtualServer{vs}}
+}
+
+func TestValidateOnline(t *testing.T) {
+	fb := solus.StorageType{Name: solus.StorageTypeNameFB}
+	thinLVM := solus.StorageType{Name: solus.StorageTypeNameThinLVM}
+
+	t.Run("valid", func(t *testing.T) {
+		api := newValidateOnlineTestAPI(t, thinLVM, []solus.Storage{
+			{ID: 1, Type: fb, FreeSpace: 15},
+			{ID: 2, Type: thinLVM, FreeSpace: 25},
+		})
+		plan := testValidateOnlinePlan(api.settings())
+
+		client, err := newSolusClient(plan.Settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := plan.ValidateOnline(client, false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("offer storage type is missing on compute resource", func(t *testing.T) {
+		// File based storage has space for both disks, but the offer places the additional disk on thin LVM.
+		api := newValidateOnlineTestAPI(t, "thinlvm", []solus.Storage{
+			{ID: 1, Type: fb, FreeSpace: 100},
+		})
+		plan := testValidateOnlinePlan(api.settings())
+
+		client, err := newSolusClient(plan.Settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = plan.ValidateOnline(client, false)
+		if err == nil || !strings.Contains(err.Error(), `compute resource 1: compute resource "cr": no "thinlvm" storage for 20 GiB of disks`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("additional interface is mapped to IPv6 IP block", func(t *testing.T) {
+		api := newValidateOnlineTestAPI(t, thinLVM, []solus.Storage{
+			{ID: 1, Type: fb, FreeSpace: 15},
+			{ID: 2, Type: thinLVM, FreeSpace: 25},
+		})
+		attached := []map[string]interface{}{{"id": 1}}
+		api.respond("GET /api/v1/ip_blocks/4", map[string]interface{}{
+			"id": 4, "name": "public", "type": "IPv4", "list_type": "range", "compute_resources": attached,
+		})
+		api.respond("GET /api/v1/ip_blocks/6", map[string]interface{}{
+			"id": 6, "name": "private v6", "type": "IPv6", "list_type": "range", "compute_resources": attached,
+		})
+
+		settings := api.settings()
+		settings.Defaults.PortgroupToNetwork = map[string]NetworkMapping{
+			"VM Network": {IPBlockID: 4},
+			"Private":    {IPBlockID: 6},
+		}
+		plan := testValidateOnlinePlan(settings)
+		plan.VirtualServers[0].NetworkInterfaces = []NetworkInterface{
+			{Device: "ethernet0", NetworkName: "VM Network"},
+			{Device: "ethernet1", NetworkName: "Private"},
+		}
+
+		client, err := newSolusClient(plan.Settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = plan.ValidateOnline(client, false)
+		if err == nil || !strings.Contains(err.Error(), `IP block 6: IP block "private v6" is mapped to network interfaces vm ethernet1`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("already created virtual server is skipped", func(t *testing.T) {
+		api := newValidateOnlineTestAPI(t, thinLVM, nil)
+		plan := testValidateOnlinePlan(api.settings())
+		plan.VirtualServers[0].VirtualServerID = 42
+
+		client, err := newSolusClient(plan.Settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := plan.ValidateOnline(client, false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n := api.count("GET /api/v1/offers/2"); n != 0 {
+			t.Errorf("offer of created virtual server is fetched %d times", n)
+		}
+	})
+}
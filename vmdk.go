@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/solusio/import-vmware/common"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	vmdkSectorSize = 512
+
+	// vmdkNoParentCID is a parentCID value of a disk without a parent.
+	vmdkNoParentCID = "ffffffff"
+
+	// vmdkMaxDescriptorSize is a limit for a text descriptor file, anything bigger is
+	// likely an extent file which is referenced by mistake.
+	vmdkMaxDescriptorSize = 64 * common.KiB
+
+	// vmdkSparseMagic is a magic number of hosted sparse extent with embedded descriptor.
+	vmdkSparseMagic = "KDMV"
+)
+
+// VMDK create types.
+// See https://www.vmware.com/app/vmdk/?src=vmdk "Virtual Disk Format 5.0".
+const (
+	VMDKCreateTypeVMFS                 = "vmfs"
+	VMDKCreateTypeVMFSSparse           = "vmfsSparse"
+	VMDKCreateTypeSESparse             = "seSparse"
+	VMDKCreateTypeMonolithicFlat       = "monolithicFlat"
+	VMDKCreateTypeMonolithicSparse     = "monolithicSparse"
+	VMDKCreateTypeTwoGbMaxExtentFlat   = "twoGbMaxExtentFlat"
+	VMDKCreateTypeTwoGbMaxExtentSparse = "twoGbMaxExtentSparse"
+	VMDKCreateTypeStreamOptimized      = "streamOptimized"
+)
+
+// Disk provisioning types stored in import plan.
+const (
+	DiskProvisioningThin  = "thin"
+	DiskProvisioningThick = "thick"
+)
+
+// VMDKDescriptor is a parsed VMDK descriptor file.
+//
+//	# Disk DescriptorFile
+//	version=1
+//	CID=fffffffe
+//	parentCID=ffffffff
+//	createType="vmfs"
+//
+//	# Extent description
+//	RW 41943040 VMFS "testvm-flat.vmdk"
+//
+//	# The Disk Data Base
+//	ddb.thinProvisioned = "1"
+type VMDKDescriptor struct {
+	// Path to the descriptor file.
+	Path               string
+	Version            int
+	CID                string
+	ParentCID          string
+	CreateType         string
+	ParentFileNameHint string
+	Extents            []VMDKExtent
+	// DDB contains all `ddb.*` keys, like `ddb.adapterType` or `ddb.uuid`.
+	DDB map[string]string
+}
+
+// VMDKExtent is a single extent line of VMDK descriptor.
+type VMDKExtent struct {
+	// Access is one of RW, RDONLY or NOACCESS.
+	Access string
+	// Sectors is a size of the extent in 512 bytes sectors.
+	Sectors int64
+	// Type is one of FLAT, SPARSE, ZERO, VMFS, VMFSSPARSE, SESPARSE, VMFSRDM or VMFSRAW.
+	Type string
+	// Filename is an extent file name as it is written in the descriptor.
+	Filename string
+	// Offset is an offset of the extent data in the extent file, used by FLAT extents only.
+	Offset int64
+}
+
+// CapacityBytes returns provisioned capacity of the disk.
+func (d VMDKDescriptor) CapacityBytes() int64 {
+	var sectors int64
+	for _, e := range d.Extents {
+		sectors += e.Sectors
+	}
+	return sectors * vmdkSectorSize
+}
+
+// CapacityGiB returns provisioned capacity of the disk rounded up to GiB, so a disk
+// created with this size is never smaller than the source one.
+func (d VMDKDescriptor) CapacityGiB() int {
+	return int((d.CapacityBytes() + common.GiB - 1) / common.GiB)
+}
+
+// HasParent returns true if the disk is a delta disk of a snapshot.
+func (d VMDKDescriptor) HasParent() bool {
+	return d.ParentCID != "" && !strings.EqualFold(d.ParentCID, vmdkNoParentCID)
+}
+
+// ProvisioningType returns thin or thick provisioning type of the disk.
+func (d VMDKDescriptor) ProvisioningType() string {
+	if d.DDB["ddb.thinProvisioned"] == "1" {
+		return DiskProvisioningThin
+	}
+
+	switch d.CreateType {
+	case VMDKCreateTypeVMFSSparse,
+		VMDKCreateTypeSESparse,
+		VMDKCreateTypeMonolithicSparse,
+		VMDKCreateTypeTwoGbMaxExtentSparse,
+		VMDKCreateTypeStreamOptimized:
+		return DiskProvisioningThin
+	}
+
+	return DiskProvisioningThick
+}
+
+// checkImportable returns an error if the disk can't be imported either directly or from a temporary clone.
+func (d VMDKDescriptor) checkImportable() error {
+	return checkImportableCreateType(d.CreateType)
+}
+
+// isDirectlyImportableCreateType returns true if virt-v2v can read the disk over SSH as is. It reads
+// a flat extent `<disk>-flat.vmdk` directly, so other disks are imported from a temporary clone.
+func isDirectlyImportableCreateType(createType string) bool {
+	switch createType {
+	case VMDKCreateTypeVMFS, VMDKCreateTypeMonolithicFlat:
+		return true
+	}
+	return false
+}
+
+// checkImportableCreateType returns an error if the disk can't be cloned to a flat disk with `vmkfstools -i` either.
+func checkImportableCreateType(createType string) error {
+	switch createType {
+	case VMDKCreateTypeVMFS,
+		VMDKCreateTypeMonolithicFlat,
+		VMDKCreateTypeVMFSSparse,
+		VMDKCreateTypeSESparse,
+		VMDKCreateTypeMonolithicSparse,
+		VMDKCreateTypeTwoGbMaxExtentFlat,
+		VMDKCreateTypeTwoGbMaxExtentSparse:
+		return nil
+	}
+	return fmt.Errorf("disk create type %q is not supported, "+
+		"convert the disk with `vmkfstools -i <disk>.vmdk -d thin <new disk>.vmdk`", createType)
+}
+
+// ExtentPaths returns full paths of extent files.
+func (d VMDKDescriptor) ExtentPaths() []string {
+	paths := make([]string, 0, len(d.Extents))
+	for _, e := range d.Extents {
+		if e.Filename == "" {
+			continue
+		}
+		if filepath.IsAbs(e.Filename) {
+			paths = append(paths, e.Filename)
+			continue
+		}
+		paths = append(paths, filepath.Join(filepath.Dir(d.Path), e.Filename))
+	}
+	return paths
+}
+
+// ReadVMDKDescriptor reads VMDK descriptor from a text descriptor file or from
+// a sparse extent with embedded descriptor.
+func ReadVMDKDescriptor(fsys FS, path string) (VMDKDescriptor, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return VMDKDescriptor{}, err
+	}
+	defer common.CloseWrapper(f)
+
+	b, err := readVMDKDescriptorBytes(f)
+	if err != nil {
+		return VMDKDescriptor{}, fmt.Errorf("read descriptor %q: %w", path, err)
+	}
+
+	d, err := ParseVMDKDescriptor(bytes.NewReader(b))
+	if err != nil {
+		return VMDKDescriptor{}, fmt.Errorf("parse descriptor %q: %w", path, err)
+	}
+	d.Path = path
+
+	return d, nil
+}
+
+// readVMDKDescriptorBytes reads the descriptor sequentially, so only its beginning is read
+// from a remote extent file.
+func readVMDKDescriptorBytes(r io.Reader) ([]byte, error) {
+	// Sparse extent header:
+	// magic uint32, version uint32, flags uint32, capacity uint64, grainSize uint64,
+	// descriptorOffset uint64, descriptorSize uint64, ...
+	header := make([]byte, 44)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	if n == len(header) && string(header[:4]) == vmdkSparseMagic {
+		offset := int64(binary.LittleEndian.Uint64(header[28:36])) * vmdkSectorSize
+		size := int64(binary.LittleEndian.Uint64(header[36:44])) * vmdkSectorSize
+		if offset == 0 || size == 0 {
+			return nil, fmt.Errorf("sparse extent has no embedded descriptor")
+		}
+		if offset < int64(len(header)) || offset > vmdkMaxDescriptorSize {
+			return nil, fmt.Errorf("embedded descriptor offset %d is invalid", offset)
+		}
+		if size > vmdkMaxDescriptorSize {
+			return nil, fmt.Errorf("embedded descriptor size %d is too big", size)
+		}
+
+		if _, err := io.CopyN(io.Discard, r, offset-int64(len(header))); err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, size)
+		n, err := io.ReadFull(r, b)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return bytes.TrimRight(b[:n], "\x00"), nil
+	}
+
+	rest, err := io.ReadAll(io.LimitReader(r, vmdkMaxDescriptorSize+1-int64(n)))
+	if err != nil {
+		return nil, err
+	}
+
+	b := append(header[:n], rest...)
+	if len(b) > vmdkMaxDescriptorSize {
+		return nil, fmt.Errorf("file is too big for a descriptor, looks like an extent file")
+	}
+
+	return b, nil
+}
+
+// ParseVMDKDescriptor parses text VMDK descriptor.
+func ParseVMDKDescriptor(r io.Reader) (VMDKDescriptor, error) {
+	d := VMDKDescriptor{
+		DDB: map[string]string{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimRight(scanner.Text(), "\x00"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if isVMDKExtentLine(line) {
+			e, err := parseVMDKExtent(line)
+			if err != nil {
+				return VMDKDescriptor{}, err
+			}
+			d.Extents = append(d.Extents, e)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return VMDKDescriptor{}, fmt.Errorf("invalid line: %q", line)
+		}
+
+		key = strings.TrimSpace(key)
+		value = unquoteVMDKValue(value)
+
+		switch {
+		case key == "version":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return VMDKDescriptor{}, fmt.Errorf("invalid version %q: %w", value, err)
+			}
+			d.Version = v
+		case key == "CID":
+			d.CID = strings.ToLower(value)
+		case key == "parentCID":
+			d.ParentCID = strings.ToLower(value)
+		case key == "createType":
+			d.CreateType = value
+		case key == "parentFileNameHint":
+			d.ParentFileNameHint = value
+		case strings.HasPrefix(key, "ddb."):
+			d.DDB[key] = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return VMDKDescriptor{}, err
+	}
+
+	if d.CreateType == "" {
+		return VMDKDescriptor{}, fmt.Errorf("createType is not found")
+	}
+
+	if len(d.Extents) == 0 {
+		return VMDKDescriptor{}, fmt.Errorf("no extents found")
+	}
+
+	return d, nil
+}
+
+func isVMDKExtentLine(line string) bool {
+	for _, access := range []string{"RW ", "RDONLY ", "NOACCESS "} {
+		if strings.HasPrefix(line, access) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVMDKExtent parses extent line like `RW 41943040 VMFS "testvm-flat.vmdk" 0`.
+func parseVMDKExtent(line string) (VMDKExtent, error) {
+	var e VMDKExtent
+
+	// Filename is quoted and may contain spaces, so split it out first.
+	head, rest, hasFilename := strings.Cut(line, `"`)
+	fields := strings.Fields(head)
+	if len(fields) < 3 {
+		return VMDKExtent{}, fmt.Errorf("invalid extent line: %q", line)
+	}
+
+	sectors, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return VMDKExtent{}, fmt.Errorf("invalid extent size in line %q: %w", line, err)
+	}
+
+	e.Access = fields[0]
+	e.Sectors = sectors
+	e.Type = fields[2]
+
+	if !hasFilename {
+		// ZERO extent has no file.
+		return e, nil
+	}
+
+	filename, tail, ok := strings.Cut(rest, `"`)
+	if !ok {
+		return VMDKExtent{}, fmt.Errorf("unterminated filename in extent line: %q", line)
+	}
+	e.Filename = filename
+
+	if tail = strings.TrimSpace(tail); tail != "" {
+		offset, err := strconv.ParseInt(strings.Fields(tail)[0], 10, 64)
+		if err != nil {
+			return VMDKExtent{}, fmt.Errorf("invalid extent offset in line %q: %w", line, err)
+		}
+		e.Offset = offset
+	}
+
+	return e, nil
+}
+
+func unquoteVMDKValue(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
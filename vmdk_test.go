@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestParseVMDKDescriptor(t *testing.T) {
+	tests := []struct {
+		name           string
+		descriptor     string
+		wantCreateType string
+		wantParent     bool
+		wantCapacity   int64
+		wantProvision  string
+		wantExtents    []VMDKExtent
+	}{
+		{
+			name: "monolithicFlat",
+			descriptor: `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="monolithicFlat"
+
+# Extent description
+RW 20971520 FLAT "test vm-flat.vmdk" 0
+
+# The Disk Data Base
+ddb.adapterType = "lsilogic"
+`,
+			wantCreateType: VMDKCreateTypeMonolithicFlat,
+			wantCapacity:   10 << 30,
+			wantProvision:  DiskProvisioningThick,
+			wantExtents:    []VMDKExtent{{Access: "RW", Sectors: 20971520, Type: "FLAT", Filename: "test vm-flat.vmdk"}},
+		},
+		{
+			name: "vmfs thin",
+			descriptor: `version=1
+CID=0A1B2C3D
+parentCID=ffffffff
+createType="vmfs"
+RW 41943040 VMFS "vm-flat.vmdk"
+ddb.thinProvisioned = "1"
+`,
+			wantCreateType: VMDKCreateTypeVMFS,
+			wantCapacity:   20 << 30,
+			wantProvision:  DiskProvisioningThin,
+			wantExtents:    []VMDKExtent{{Access: "RW", Sectors: 41943040, Type: "VMFS", Filename: "vm-flat.vmdk"}},
+		},
+		{
+			name: "vmfsSparse",
+			descriptor: `version=1
+CID=2d7b6f7e
+parentCID=0a1b2c3d
+createType="vmfsSparse"
+parentFileNameHint="vm.vmdk"
+RW 41943040 VMFSSPARSE "vm-000001-delta.vmdk"
+`,
+			wantCreateType: VMDKCreateTypeVMFSSparse,
+			wantParent:     true,
+			wantCapacity:   20 << 30,
+			wantProvision:  DiskProvisioningThin,
+			wantExtents:    []VMDKExtent{{Access: "RW", Sectors: 41943040, Type: "VMFSSPARSE", Filename: "vm-000001-delta.vmdk"}},
+		},
+		{
+			name: "seSparse",
+			descriptor: `version=1
+CID=2d7b6f7e
+parentCID=0a1b2c3d
+createType="seSparse"
+parentFileNameHint="/vmfs/volumes/datastore1/vm/vm.vmdk"
+RW 41943040 SESPARSE "vm-000001-sesparse.vmdk"
+`,
+			wantCreateType: VMDKCreateTypeSESparse,
+			wantParent:     true,
+			wantCapacity:   20 << 30,
+			wantProvision:  DiskProvisioningThin,
+			wantExtents:    []VMDKExtent{{Access: "RW", Sectors: 41943040, Type: "SESPARSE", Filename: "vm-000001-sesparse.vmdk"}},
+		},
+		{
+			name: "twoGbMaxExtentSparse",
+			descriptor: `version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="twoGbMaxExtentSparse"
+RW 4192256 SPARSE "vm-s001.vmdk"
+RW 4192256 SPARSE "vm-s002.vmdk"
+RW 8192 SPARSE "vm-s003.vmdk"
+`,
+			wantCreateType: VMDKCreateTypeTwoGbMaxExtentSparse,
+			wantCapacity:   (4192256*2 + 8192) * vmdkSectorSize,
+			wantProvision:  DiskProvisioningThin,
+			wantExtents: []VMDKExtent{
+				{Access: "RW", Sectors: 4192256, Type: "SPARSE", Filename: "vm-s001.vmdk"},
+				{Access: "RW", Sectors: 4192256, Type: "SPARSE", Filename: "vm-s002.vmdk"},
+				{Access: "RW", Sectors: 8192, Type: "SPARSE", Filename: "vm-s003.vmdk"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseVMDKDescriptor(strings.NewReader(tt.descriptor))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if d.CreateType != tt.wantCreateType {
+				t.Errorf("create type is %q, expected %q", d.CreateType, tt.wantCreateType)
+			}
+			if d.HasParent() != tt.wantParent {
+				t.Errorf("has parent is %t, expected %t", d.HasParent(), tt.wantParent)
+			}
+			if d.CapacityBytes() != tt.wantCapacity {
+				t.Errorf("capacity is %d, expected %d", d.CapacityBytes(), tt.wantCapacity)
+			}
+			if d.ProvisioningType() != tt.wantProvision {
+				t.Errorf("provisioning type is %q, expected %q", d.ProvisioningType(), tt.wantProvision)
+			}
+			if len(d.Extents) != len(tt.wantExtents) {
+				t.Fatalf("extents are %v, expected %v", d.Extents, tt.wantExtents)
+			}
+			for i := range tt.wantExtents {
+				if d.Extents[i] != tt.wantExtents[i] {
+					t.Errorf("extent %d is %v, expected %v", i, d.Extents[i], tt.wantExtents[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseVMDKDescriptorErrors(t *testing.T) {
+	tests := map[string]string{
+		"no create type": `version=1
+RW 8192 VMFS "vm-flat.vmdk"
+`,
+		"no extents": `version=1
+createType="vmfs"
+`,
+		"invalid line": `createType="vmfs"
+garbage
+RW 8192 VMFS "vm-flat.vmdk"
+`,
+		"invalid version": `version=one
+createType="vmfs"
+RW 8192 VMFS "vm-flat.vmdk"
+`,
+	}
+
+	for name, descriptor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseVMDKDescriptor(strings.NewReader(descriptor)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestParseVMDKExtent(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    VMDKExtent
+		wantErr bool
+	}{
+		{
+			line: `RW 41943040 VMFS "testvm-flat.vmdk"`,
+			want: VMDKExtent{Access: "RW", Sectors: 41943040, Type: "VMFS", Filename: "testvm-flat.vmdk"},
+		},
+		{
+			line: `RDONLY 2048 FLAT "test vm (1)-flat.vmdk" 128`,
+			want: VMDKExtent{Access: "RDONLY", Sectors: 2048, Type: "FLAT", Filename: "test vm (1)-flat.vmdk", Offset: 128},
+		},
+		{
+			line: `RW 2048 ZERO`,
+			want: VMDKExtent{Access: "RW", Sectors: 2048, Type: "ZERO"},
+		},
+		{line: `RW 2048`, wantErr: true},
+		{line: `RW many VMFS "vm-flat.vmdk"`, wantErr: true},
+		{line: `RW 2048 VMFS "vm-flat.vmdk`, wantErr: true},
+		{line: `RW 2048 FLAT "vm-flat.vmdk" start`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVMDKExtent(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseVMDKExtent(%q) is expected to fail", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseVMDKExtent(%q): unexpected error: %s", tt.line, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVMDKExtent(%q) = %v, expected %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// testSparseExtent returns a hosted sparse extent with the descriptor embedded at the second sector.
+func testSparseExtent(descriptor string) []byte {
+	b := make([]byte, 4*vmdkSectorSize)
+	copy(b, vmdkSparseMagic)
+	binary.LittleEndian.PutUint32(b[4:], 1)
+	binary.LittleEndian.PutUint64(b[12:], 8192)
+	binary.LittleEndian.PutUint64(b[20:], 128)
+	binary.LittleEndian.PutUint64(b[28:], 1)
+	binary.LittleEndian.PutUint64(b[36:], 2)
+	copy(b[vmdkSectorSize:], descriptor)
+	return b
+}
+
+func TestReadVMDKDescriptorBytes(t *testing.T) {
+	const descriptor = `version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="monolithicSparse"
+RW 8192 SPARSE "vm.vmdk"
+`
+
+	t.Run("text descriptor", func(t *testing.T) {
+		b, err := readVMDKDescriptorBytes(strings.NewReader(descriptor))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(b) != descriptor {
+			t.Errorf("descriptor is %q, expected %q", b, descriptor)
+		}
+	})
+
+	t.Run("short text descriptor", func(t *testing.T) {
+		b, err := readVMDKDescriptorBytes(strings.NewReader("version=1\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(b) != "version=1\n" {
+			t.Errorf("descriptor is %q", b)
+		}
+	})
+
+	t.Run("embedded sparse descriptor", func(t *testing.T) {
+		b, err := readVMDKDescriptorBytes(bytes.NewReader(testSparseExtent(descriptor)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(b) != descriptor {
+			t.Errorf("descriptor is %q, expected %q", b, descriptor)
+		}
+
+		d, err := ParseVMDKDescriptor(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if d.CreateType != VMDKCreateTypeMonolithicSparse || d.ProvisioningType() != DiskProvisioningThin {
+			t.Errorf("unexpected descriptor %+v", d)
+		}
+	})
+
+	t.Run("sparse extent without descriptor", func(t *testing.T) {
+		b := testSparseExtent("")
+		binary.LittleEndian.PutUint64(b[28:], 0)
+		if _, err := readVMDKDescriptorBytes(bytes.NewReader(b)); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("extent file", func(t *testing.T) {
+		r := bytes.NewReader(make([]byte, vmdkMaxDescriptorSize+1))
+		if _, err := readVMDKDescriptorBytes(r); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
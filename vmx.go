@@ -1,16 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/solusio/import-vmware/common"
 	vmx "github.com/solusio/import-vmware/govmx"
 	"github.com/solusio/solus-go-sdk"
-	"log"
-	"os"
+	"io"
 	"path/filepath"
 	"strings"
 )
 
+var errNoVMXFile = errors.New("no vmx file found")
+
 type VMXFile struct {
 	ParentPath string
 	Name       string `vmx:"displayName"`
@@ -21,8 +23,19 @@ type VMXFile struct {
 	// guestOS = "debian12-64"
 	// guestOS = "windows2022srvNext-64"
 	GuestOS string `vmx:"guestOS"`
+	// annotation = "Web server|0A|Owner: ops"
+	Annotation string `vmx:"annotation"`
 	// firmware = "efi"
-	Firmware    solus.Firmware   `vmx:"firmware"`
+	Firmware solus.Firmware `vmx:"firmware"`
+	// bios.bootOrder = "hdd,cdrom"
+	BootOrder string `vmx:"bios.bootOrder"`
+	// bios.hddOrder = "scsi0:1"
+	HDDOrder string `vmx:"bios.hddOrder"`
+	// cleanShutdown = "FALSE", it's empty if the virtual machine was never powered on
+	CleanShutdown string `vmx:"cleanShutdown"`
+	// softPowerOff = "TRUE"
+	SoftPowerOff string `vmx:"softPowerOff"`
+
 	IDEDevices  []vmx.IDEDevice  `vmx:"ide,omitempty"`
 	SCSIDevices []vmx.SCSIDevice `vmx:"scsi,omitempty"`
 	SATADevices []vmx.SATADevice `vmx:"sata,omitempty"`
@@ -30,14 +43,13 @@ type VMXFile struct {
 	Ethernet    []vmx.Ethernet   `vmx:"ethernet,omitempty"`
 }
 
-func ParseVMWareVirtualServerPath(path string) (VirtualServer, error) {
-	dir, err := os.ReadDir(path)
+func ParseVMWareVirtualServerPath(fsys FS, path, snapshotMode string) (VirtualServer, error) {
+	dir, err := fsys.ReadDir(path)
 	if err != nil {
 		return VirtualServer{}, err
 	}
 
 	var vmxFilePath string
-	var disksPaths []string
 	for _, f := range dir {
 		if f.IsDir() {
 			continue
@@ -46,22 +58,15 @@ func ParseVMWareVirtualServerPath(path string) (VirtualServer, error) {
 		if filepath.Ext(f.Name()) == ".vmx" {
 			vmxFilePath = filepath.Join(path, f.Name())
 		}
-
-		if filepath.Ext(f.Name()) == ".vmdk" {
-			disksPaths = append(disksPaths, f.Name())
-		}
-
 	}
 
 	if vmxFilePath == "" {
-		return VirtualServer{}, fmt.Errorf("no vmx file found in %s", path)
+		return VirtualServer{}, fmt.Errorf("%w in %s", errNoVMXFile, path)
 	}
 
-	if len(disksPaths) == 0 {
-		return VirtualServer{}, fmt.Errorf("no vmdk files found in %s", path)
-	}
+	// Disks are not looked for in the directory, since they may be stored on other datastores.
 
-	vmxFile, err := ParseVMXFile(vmxFilePath)
+	vmxFile, err := ParseVMXFile(fsys, vmxFilePath)
 	if err != nil {
 		return VirtualServer{}, err
 	}
@@ -70,7 +75,7 @@ func ParseVMWareVirtualServerPath(path string) (VirtualServer, error) {
 		vmxFile.Firmware = "bios"
 	}
 
-	primaryDisk, additionalDisks, err := GetDisksFromVMX(vmxFile)
+	primaryDisk, additionalDisks, err := GetDisksFromVMX(fsys, vmxFile, snapshotMode)
 	if err != nil {
 		return VirtualServer{}, fmt.Errorf("failed to get disks from vmx file %q: %w", vmxFilePath, err)
 	}
@@ -93,15 +98,12 @@ func ParseVMWareVirtualServerPath(path string) (VirtualServer, error) {
 		IsAdditionalIPsAvailable: false,
 	}
 
-	var macAddress *string
+	networkInterfaces := networkInterfacesFromVMX(vmxFile.Ethernet)
 
-	for _, e := range vmxFile.Ethernet {
-		if e.AddressType == vmx.MAC_TYPE_GENERATED && e.GeneratedAddress != "" {
-			macAddress = &e.GeneratedAddress
-		}
-		if e.AddressType == vmx.MAC_TYPE_STATIC && e.Address != "" {
-			macAddress = &e.Address
-		}
+	// MAC address of the primary interface is set on creation, others are applied after disks import.
+	var macAddress *string
+	if len(networkInterfaces) > 0 && networkInterfaces[0].MacAddress != "" {
+		macAddress = &networkInterfaces[0].MacAddress
 	}
 
 	return VirtualServer{
@@ -110,19 +112,31 @@ func ParseVMWareVirtualServerPath(path string) (VirtualServer, error) {
 		OriginName:            vmxFile.Name,
 		Hostname:              vmxNameToHostname(vmxFile.Name),
 		GuestOS:               vmxFile.GuestOS,
+		Annotation:            vmxFile.Annotation,
 		CustomPlan:            plan,
 		PrimaryDiskSourcePath: primaryDisk.SourcePath,
+		PrimaryDisk:           &primaryDisk,
+		PrimaryDiskDevice:     primaryDisk.Device,
 		AdditionalDisks:       additionalDisks,
 		MacAddress:            macAddress,
+		NetworkInterfaces:     networkInterfaces,
 		Firmware:              &vmxFile.Firmware,
+		SnapshotMode:          snapshotModeOf(primaryDisk, additionalDisks, snapshotMode),
+		ShutdownIssues:        uncleanShutdownReasons(vmxFile, vmxFilePath, dir),
 	}, nil
 }
 
-func ParseVMXFile(path string) (VMXFile, error) {
-	b, err := os.ReadFile(path)
+func ParseVMXFile(fsys FS, path string) (VMXFile, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return VMXFile{}, err
 	}
+	defer common.CloseWrapper(f)
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return VMXFile{}, fmt.Errorf("failed to read vmx file %q: %w", path, err)
+	}
 	v := VMXFile{}
 
 	if err := vmx.Unmarshal(b, &v); err != nil {
@@ -138,11 +152,11 @@ func ParseVMXFile(path string) (VMXFile, error) {
 	return v, nil
 }
 
-// GetDisksFromVMX returns primary and additional disks from VMX file.
-func GetDisksFromVMX(v VMXFile) (Disk, []Disk, error) {
-	primary := Disk{}
-	var additional []Disk
-
+// GetDisksFromVMX returns primary and additional disks from VMX file. Additional disks are in the order
+// they are converted by virt-v2v.
+// Disks with snapshots are allowed if they are imported in the snapshot mode.
+func GetDisksFromVMX(fsys FS, v VMXFile, snapshotMode string) (Disk, []Disk, error) {
+	var disks []Disk
 	var devices []vmx.Device
 
 	for _, ide := range v.IDEDevices {
@@ -150,18 +164,10 @@ func GetDisksFromVMX(v VMXFile) (Disk, []Disk, error) {
 	}
 
 	for _, sata := range v.SATADevices {
-		if sata.Type == "cdrom-image" {
-			continue
-		}
-
 		devices = append(devices, sata.Device)
 	}
 
 	for _, scsi := range v.SCSIDevices {
-		if scsi.Type != "scsi-hardDisk" {
-			continue
-		}
-
 		devices = append(devices, scsi.Device)
 	}
 
@@ -169,67 +175,101 @@ func GetDisksFromVMX(v VMXFile) (Disk, []Disk, error) {
 		devices = append(devices, nvme.Device)
 	}
 
-	originVMName := filepath.Base(v.ParentPath)
-
 	for _, dev := range devices {
-		if dev.Filename == "" {
+		if !isVMXHardDisk(dev) {
 			continue
 		}
 
-		fullPath := filepath.Join(v.ParentPath, dev.Filename)
-		if !common.IsExists(fullPath) {
-			continue
+		fullPath := resolveVMXDiskPath(v, dev.Filename)
+		exists, err := isExists(fsys, fullPath)
+		if err != nil {
+			return Disk{}, nil, err
 		}
-
-		if strings.Contains(dev.Filename, "-0") { // scsi0:1.fileName = "testvm_1-000001.vmdk"
-			return Disk{}, nil, fmt.Errorf("disk filename %q looks like has a snapshot", dev.Filename)
+		if !exists {
+			return Disk{}, nil, fmt.Errorf("disk %q of device %s is not found at %q", dev.Filename, dev.VMXID, fullPath)
 		}
 
-		size, err := getDiskSizeGiB(fullPath)
+		chain, err := ResolveVMDKChain(fsys, fullPath)
 		if err != nil {
 			return Disk{}, nil, err
 		}
 
-		// Determine -flat file to get actual size.
-		flatPath := strings.Replace(fullPath, ".vmdk", "-flat.vmdk", 1)
-		if common.IsExists(flatPath) {
-			flatSize, err := getDiskSizeGiB(flatPath)
-			if err != nil {
-				return Disk{}, nil, err
-			}
-			size = flatSize
+		if len(chain) > 1 && !importsSnapshots(snapshotMode) {
+			return Disk{}, nil, fmt.Errorf("disk %q has a snapshot chain of %d disks, "+
+				"remove snapshots or set snapshot mode to %q or %q", dev.Filename, len(chain), SnapshotModeCurrent, SnapshotModeConsolidate)
 		}
 
-		disk := Disk{
-			Name:       fullPath,
-			SourcePath: fullPath,
-			Size:       size,
+		if err := chain[len(chain)-1].checkImportable(); err != nil {
+			return Disk{}, nil, fmt.Errorf("disk %q of device %s: %w", dev.Filename, dev.VMXID, err)
 		}
 
-		log.Println(strings.TrimSuffix(dev.Filename, ".vmdk"), originVMName)
+		disk := diskFromVMDKChain(chain, snapshotMode)
+		disk.VMXFilename = dev.Filename
+		disk.Datastore = datastoreNameOf(fullPath)
+		disk.Device = dev.VMXID
 
-		if strings.TrimSuffix(dev.Filename, ".vmdk") == originVMName { // sata0:1.fileName = "test vm.vmdk"
-			primary = disk
-			continue
-		}
-
-		additional = append(additional, disk)
+		disks = append(disks, disk)
 	}
 
-	if primary.SourcePath == "" {
+	if len(disks) == 0 {
 		return Disk{}, nil, fmt.Errorf("primary disk not found")
 	}
 
-	return primary, additional, nil
+	sortDisksByDevice(disks)
+	p := selectPrimaryDisk(v, disks)
+
+	additional := append(append([]Disk{}, disks[:p]...), disks[p+1:]...)
+
+	return disks[p], additional, nil
 }
 
-func getDiskSizeGiB(path string) (int, error) {
-	size, err := common.GetSize(path)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get size of file %q: %w", path, err)
+// isVMXHardDisk returns true if the device is a present hard disk. CD-ROMs and pass-through devices
+// are ignored on any bus by device type, like scsi0:1.deviceType = "cdrom-image" or
+// ide1:0.deviceType = "atapi-cdrom". Controllers like scsi0 have no file name.
+func isVMXHardDisk(d vmx.Device) bool {
+	if !d.Present || d.Filename == "" {
+		return false
 	}
 
-	return int(size / 1024 / 1024 / 1024), nil
+	t := strings.ToLower(d.Type)
+	if t != "" && !strings.HasSuffix(t, "harddisk") && t != "disk" {
+		return false
+	}
+
+	// A disk without device type may still reference an ISO image.
+	return strings.EqualFold(filepath.Ext(d.Filename), ".vmdk")
+}
+
+// resolveVMXDiskPath returns a full path of the disk referenced by VMX file. The filename is either
+// relative to the VMX file directory or absolute like /vmfs/volumes/datastore2/vm/vm_1.vmdk
+// for a disk stored on another datastore.
+func resolveVMXDiskPath(v VMXFile, filename string) string {
+	if filepath.IsAbs(filename) {
+		return filepath.Clean(filename)
+	}
+	return filepath.Join(v.ParentPath, filename)
+}
+
+// datastoreNameOf returns a datastore name of the path like datastore1 for
+// /vmfs/volumes/datastore1/vm/vm.vmdk, it's empty if the path is not on a datastore.
+func datastoreNameOf(path string) string {
+	rel, err := filepath.Rel(esxiVolumesPath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return strings.Split(rel, string(filepath.Separator))[0]
+}
+
+func diskFromVMDKDescriptor(d VMDKDescriptor) Disk {
+	return Disk{
+		Name:             d.Path,
+		SourcePath:       d.Path,
+		Size:             d.CapacityGiB(),
+		CapacityBytes:    d.CapacityBytes(),
+		CreateType:       d.CreateType,
+		ProvisioningType: d.ProvisioningType(),
+		Extents:          d.ExtentPaths(),
+	}
 }
 
 func vmxNameToHostname(name string) string {
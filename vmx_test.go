@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testFlatVMDK(extent string, sectors string) string {
+	return `# Disk DescriptorFile
+version=1
+CID=0a1b2c3d
+parentCID=ffffffff
+createType="vmfs"
+RW ` + sectors + ` VMFS "` + extent + `"
+ddb.thinProvisioned = "1"
+`
+}
+
+// testESXiFS returns a file system of ESXi host with two datastores:
+//   - web on datastore1 has a disk on datastore2, a CD-ROM and boots from the second disk;
+//   - db on datastore1 has a snapshot;
+//   - files on datastore1 has no VMX file, skipped on datastore2 is skipped for import.
+func testESXiFS() memFS {
+	return memFS{
+		"/vmfs/volumes/datastore1": memLink("5f1a-01"),
+		"/vmfs/volumes/datastore2": memLink("5f1b-02"),
+
+		"/vmfs/volumes/5f1a-01/.sdd.sf/vh.sf": memFile(""),
+		"/vmfs/volumes/5f1a-01/web/web.vmx": memFile(`displayName = "Web Server"
+numvcpus = "2"
+memsize = "2048"
+guestOS = "ubuntu-64"
+bios.bootOrder = "hdd"
+bios.hddOrder = "scsi0:1"
+scsi0.present = "TRUE"
+scsi0.virtualDev = "pvscsi"
+scsi0:0.present = "TRUE"
+scsi0:0.fileName = "web.vmdk"
+scsi0:0.deviceType = "scsi-hardDisk"
+scsi0:1.present = "TRUE"
+scsi0:1.fileName = "/vmfs/volumes/datastore2/web/web_1.vmdk"
+ide1:0.present = "TRUE"
+ide1:0.fileName = "/vmfs/volumes/datastore1/iso/ubuntu.iso"
+ide1:0.deviceType = "cdrom-image"
+ethernet0.present = "TRUE"
+ethernet0.virtualDev = "vmxnet3"
+ethernet0.networkName = "VM Network"
+ethernet0.addressType = "generated"
+ethernet0.generatedAddress = "00:50:56:9a:00:01"
+`),
+		"/vmfs/volumes/5f1a-01/web/web.vmdk":            memFile(testFlatVMDK("web-flat.vmdk", "20971520")),
+		"/vmfs/volumes/5f1a-01/web/web-flat.vmdk":       memFile(""),
+		"/vmfs/volumes/5f1b-02/web/web_1.vmdk":          memFile(testFlatVMDK("web_1-flat.vmdk", "41943040")),
+		"/vmfs/volumes/5f1b-02/web/web_1-flat.vmdk":     memFile(""),
+		"/vmfs/volumes/5f1a-01/iso/ubuntu.iso":          memFile(""),
+		"/vmfs/volumes/5f1a-01/db/db.vmx":               memFile("displayName = \"db\"\nguestOS = \"debian12-64\"\nsata0:0.present = \"TRUE\"\nsata0:0.fileName = \"db-000001.vmdk\"\n"),
+		"/vmfs/volumes/5f1a-01/db/db.vmdk":              memFile(testFlatVMDK("db-flat.vmdk", "20971520")),
+		"/vmfs/volumes/5f1a-01/db/db-flat.vmdk":         memFile(""),
+		"/vmfs/volumes/5f1a-01/db/db-000001-delta.vmdk": memFile(""),
+		"/vmfs/volumes/5f1a-01/db/db-000001.vmdk": memFile(`version=1
+CID=2d7b6f7e
+parentCID=0a1b2c3d
+createType="vmfsSparse"
+parentFileNameHint="db.vmdk"
+RW 20971520 VMFSSPARSE "db-000001-delta.vmdk"
+`),
+		"/vmfs/volumes/5f1a-01/files/readme.txt":     memFile(""),
+		"/vmfs/volumes/5f1b-02/skipped/skipped.vmx":  memFile(`displayName = "skipped"`),
+		"/vmfs/volumes/5f1b-02/skipped/.skip_import": memFile(""),
+	}
+}
+
+func TestParseVMWareVirtualServerPath(t *testing.T) {
+	vs, err := ParseVMWareVirtualServerPath(testESXiFS(), "/vmfs/volumes/datastore1/web", SnapshotModeFail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if vs.OriginName != "Web Server" || vs.Hostname != "web-server" || vs.GuestOS != "ubuntu-64" {
+		t.Errorf("unexpected name %q, hostname %q or guest OS %q", vs.OriginName, vs.Hostname, vs.GuestOS)
+	}
+	if vs.VMXFilePath != "/vmfs/volumes/datastore1/web/web.vmx" {
+		t.Errorf("VMX file path is %q", vs.VMXFilePath)
+	}
+	if vs.CustomPlan.Params.VCPU != 2 || vs.CustomPlan.Params.RAM != 2048*1024*1024 || vs.CustomPlan.Params.Disk != 20 {
+		t.Errorf("unexpected plan params %+v", vs.CustomPlan.Params)
+	}
+	if vs.Firmware == nil || *vs.Firmware != "bios" {
+		t.Errorf("firmware is %v, expected bios", vs.Firmware)
+	}
+	if vs.PrimaryDiskDevice != "scsi0:1" || vs.PrimaryDiskSourcePath != "/vmfs/volumes/datastore2/web/web_1.vmdk" {
+		t.Errorf("primary disk is %s %q, expected disk of boot order", vs.PrimaryDiskDevice, vs.PrimaryDiskSourcePath)
+	}
+	if len(vs.NetworkInterfaces) != 1 || vs.MacAddress == nil || *vs.MacAddress != "00:50:56:9a:00:01" {
+		t.Errorf("unexpected network interfaces %+v", vs.NetworkInterfaces)
+	}
+	if vs.SnapshotMode != "" {
+		t.Errorf("snapshot mode is %q for disks without snapshots", vs.SnapshotMode)
+	}
+}
+
+func TestParseVMWareVirtualServerPathWithoutVMX(t *testing.T) {
+	_, err := ParseVMWareVirtualServerPath(testESXiFS(), "/vmfs/volumes/datastore1/files", SnapshotModeFail)
+	if err == nil || !strings.Contains(err.Error(), errNoVMXFile.Error()) {
+		t.Errorf("expected %q error, got %v", errNoVMXFile, err)
+	}
+}
+
+func TestGetDisksFromVMX(t *testing.T) {
+	fsys := testESXiFS()
+
+	v, err := ParseVMXFile(fsys, "/vmfs/volumes/datastore1/web/web.vmx")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	primary, additional, err := GetDisksFromVMX(fsys, v, SnapshotModeFail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if primary.Device != "scsi0:1" || primary.Datastore != "datastore2" || primary.Size != 20 {
+		t.Errorf("unexpected primary disk %+v", primary)
+	}
+	if primary.ProvisioningType != DiskProvisioningThin {
+		t.Errorf("primary disk provisioning type is %q", primary.ProvisioningType)
+	}
+	if len(additional) != 1 || additional[0].Device != "scsi0:0" || additional[0].Size != 10 {
+		t.Fatalf("unexpected additional disks %+v, CD-ROM must be skipped", additional)
+	}
+	if additional[0].SourcePath != "/vmfs/volumes/datastore1/web/web.vmdk" || additional[0].Datastore != "datastore1" {
+		t.Errorf("additional disk is %q on datastore %q", additional[0].SourcePath, additional[0].Datastore)
+	}
+}
+
+func TestGetDisksFromVMXWithSnapshot(t *testing.T) {
+	fsys := testESXiFS()
+
+	v, err := ParseVMXFile(fsys, "/vmfs/volumes/datastore1/db/db.vmx")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, _, err := GetDisksFromVMX(fsys, v, SnapshotModeFail); err == nil {
+		t.Errorf("expected error for disk with snapshot in %q mode", SnapshotModeFail)
+	}
+
+	primary, _, err := GetDisksFromVMX(fsys, v, SnapshotModeConsolidate)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"/vmfs/volumes/datastore1/db/db-000001.vmdk", "/vmfs/volumes/datastore1/db/db.vmdk"}
+	if !equalStrings(primary.SnapshotChain, want) {
+		t.Errorf("snapshot chain is %v, expected %v", primary.SnapshotChain, want)
+	}
+	if primary.SourcePath != want[1] {
+		t.Errorf("source path of consolidated disk is %q, expected %q", primary.SourcePath, want[1])
+	}
+
+	primary, _, err = GetDisksFromVMX(fsys, v, SnapshotModeCurrent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if primary.SourcePath != want[0] || !primary.needsTemporaryClone(SnapshotModeCurrent) {
+		t.Errorf("current state of disk %q is expected to be cloned", primary.SourcePath)
+	}
+}
+
+func TestGetDisksFromVMXMissingDisk(t *testing.T) {
+	fsys := testESXiFS()
+	delete(fsys, "/vmfs/volumes/5f1b-02/web/web_1.vmdk")
+
+	v, err := ParseVMXFile(fsys, "/vmfs/volumes/datastore1/web/web.vmx")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, _, err := GetDisksFromVMX(fsys, v, SnapshotModeFail); err == nil || !strings.Contains(err.Error(), "is not found") {
+		t.Errorf("expected error for missing disk, got %v", err)
+	}
+}
+
+func TestGetDisksFromVMXSparseDisk(t *testing.T) {
+	fsys := testESXiFS()
+	fsys["/vmfs/volumes/5f1a-01/web/web.vmdk"] = memFile(`version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="twoGbMaxExtentSparse"
+RW 4192256 SPARSE "web-s001.vmdk"
+`)
+
+	v, err := ParseVMXFile(fsys, "/vmfs/volumes/datastore1/web/web.vmx")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Sparse disk is imported from a temporary clone.
+	_, additional, err := GetDisksFromVMX(fsys, v, SnapshotModeFail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d := additional[0]; d.CreateType != VMDKCreateTypeTwoGbMaxExtentSparse || !d.needsTemporaryClone(SnapshotModeFail) {
+		t.Errorf("sparse disk %s is expected to be cloned", d.CreateType)
+	}
+
+	fsys["/vmfs/volumes/5f1a-01/web/web.vmdk"] = memFile(`version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="streamOptimized"
+RW 4192256 SPARSE "web-s001.vmdk"
+`)
+
+	_, _, err = GetDisksFromVMX(fsys, v, SnapshotModeFail)
+	if err == nil || !strings.Contains(err.Error(), "streamOptimized") {
+		t.Errorf("expected error for stream optimized disk, got %v", err)
+	}
+}